@@ -88,8 +88,10 @@ func main() {
 		cmdDumpTsm1(opts)
 	case "verify":
 		var path string
+		var repair bool
 		fs := flag.NewFlagSet("verify", flag.ExitOnError)
 		fs.StringVar(&path, "dir", os.Getenv("HOME")+"/.influxdb", "Root storage path. [$HOME/.influxdb]")
+		fs.BoolVar(&repair, "repair", false, "Move corrupt TSM files into a corrupt directory in their shard")
 
 		fs.Usage = func() {
 			println("Usage: influx_inspect verify [options]\n\n   verifies the the checksum of shards")
@@ -102,7 +104,7 @@ func main() {
 			fmt.Printf("%v", err)
 			os.Exit(1)
 		}
-		cmdVerify(path)
+		cmdVerify(path, repair)
 	case "export":
 		var path, out, db, rp, start, end string
 		var compress bool
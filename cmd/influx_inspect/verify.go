@@ -3,15 +3,17 @@ package main
 import (
 	"fmt"
 	"hash/crc32"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"text/tabwriter"
 	"time"
 
 	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
 )
 
-func cmdVerify(path string) {
+func cmdVerify(path string, repair bool) {
 	start := time.Now()
 	dataPath := filepath.Join(path, "data")
 
@@ -27,6 +29,14 @@ func cmdVerify(path string) {
 		if err != nil {
 			return err
 		}
+		// Skip files that were already quarantined. Only the directory at
+		// data/<db>/<rp>/<id>/corrupt is the quarantine directory, since a
+		// database or retention policy may also be named corrupt.
+		if f.IsDir() && f.Name() == tsm1.CorruptDir {
+			if rel, err := filepath.Rel(dataPath, path); err == nil && len(strings.Split(rel, string(filepath.Separator))) == 4 {
+				return filepath.SkipDir
+			}
+		}
 		if filepath.Ext(path) == ext {
 			files = append(files, path)
 		}
@@ -48,8 +58,14 @@ func cmdVerify(path string) {
 
 		reader, err := tsm1.NewTSMReader(file)
 		if err != nil {
-			fmt.Printf("%v", err)
-			os.Exit(1)
+			if !repair {
+				fmt.Printf("%v", err)
+				os.Exit(1)
+			}
+			file.Close()
+			fmt.Fprintf(tw, "%s: could not open file due to error: %q\n", f, err)
+			quarantine(tw, f)
+			continue
 		}
 
 		blockItr := reader.BlockIterator()
@@ -60,9 +76,11 @@ func cmdVerify(path string) {
 			key, _, _, checksum, buf, err := blockItr.Read()
 			if err != nil {
 				brokenBlocks++
+				brokenFileBlocks++
 				fmt.Fprintf(tw, "%s: could not get checksum for key %v block %d due to error: %q\n", f, key, count, err)
 			} else if expected := crc32.ChecksumIEEE(buf); checksum != expected {
 				brokenBlocks++
+				brokenFileBlocks++
 				fmt.Fprintf(tw, "%s: got %d but expected %d for key %v, block %d\n", f, checksum, expected, key, count)
 			}
 			count++
@@ -71,8 +89,23 @@ func cmdVerify(path string) {
 			fmt.Fprintf(tw, "%s: healthy\n", f)
 		}
 		reader.Close()
+
+		if brokenFileBlocks > 0 && repair {
+			quarantine(tw, f)
+		}
 	}
 
 	fmt.Fprintf(tw, "Broken Blocks: %d / %d, in %vs\n", brokenBlocks, totalBlocks, time.Since(start).Seconds())
 	tw.Flush()
 }
+
+// quarantine moves the corrupt TSM file at path out of its shard so the shard
+// opens with its remaining files.
+func quarantine(w io.Writer, path string) {
+	newPath, err := tsm1.QuarantineTSMFile(path)
+	if err != nil {
+		fmt.Printf("%v", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(w, "%s: moved to %s\n", path, newPath)
+}
@@ -1,7 +1,6 @@
 package backup
 
 import (
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -13,8 +12,10 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/influxdata/influxdb/pkg/limiter"
 	"github.com/influxdata/influxdb/services/snapshotter"
 	"github.com/influxdata/influxdb/tcp"
 )
@@ -40,9 +41,15 @@ type Command struct {
 	Stderr io.Writer
 	Stdout io.Writer
 
-	host     string
-	path     string
-	database string
+	host        string
+	path        string
+	database    string
+	compress    bool
+	concurrency int
+	rateLimit   int
+	preHook     string
+	postHook    string
+	failureHook string
 }
 
 // NewCommand returns a new instance of Command with default settings.
@@ -64,13 +71,17 @@ func (cmd *Command) Run(args ...string) error {
 		return err
 	}
 
+	if err := cmd.runHook("pre-backup", cmd.preHook, cmd.hookEnv(retentionPolicy, shardID, since, nil)); err != nil {
+		cmd.Logger.Printf("backup failed: %v", err)
+		return err
+	}
+
 	// based on the arguments passed in we only backup the minimum
 	if shardID != "" {
 		// always backup the metastore
-		if err := cmd.backupMetastore(); err != nil {
-			return err
+		if err = cmd.backupMetastore(); err == nil {
+			err = cmd.backupShard(retentionPolicy, shardID, since)
 		}
-		err = cmd.backupShard(retentionPolicy, shardID, since)
 	} else if retentionPolicy != "" {
 		err = cmd.backupRetentionPolicy(retentionPolicy, since)
 	} else if cmd.database != "" {
@@ -79,8 +90,15 @@ func (cmd *Command) Run(args ...string) error {
 		err = cmd.backupMetastore()
 	}
 
+	if err == nil {
+		err = cmd.runHook("post-backup", cmd.postHook, cmd.hookEnv(retentionPolicy, shardID, since, nil))
+	}
+
 	if err != nil {
 		cmd.Logger.Printf("backup failed: %v", err)
+		if err := cmd.runHook("failure", cmd.failureHook, cmd.hookEnv(retentionPolicy, shardID, since, err)); err != nil {
+			cmd.Logger.Print(err)
+		}
 		return err
 	}
 
@@ -97,6 +115,12 @@ func (cmd *Command) parseFlags(args []string) (retentionPolicy, shardID string,
 	fs.StringVar(&cmd.database, "database", "", "")
 	fs.StringVar(&retentionPolicy, "retention", "", "")
 	fs.StringVar(&shardID, "shard", "", "")
+	fs.BoolVar(&cmd.compress, "compress", false, "")
+	fs.IntVar(&cmd.concurrency, "concurrency", 1, "")
+	fs.IntVar(&cmd.rateLimit, "rate-limit", 0, "")
+	fs.StringVar(&cmd.preHook, "pre-hook", "", "")
+	fs.StringVar(&cmd.postHook, "post-hook", "", "")
+	fs.StringVar(&cmd.failureHook, "failure-hook", "", "")
 	var sinceArg string
 	fs.StringVar(&sinceArg, "since", "", "")
 
@@ -114,6 +138,12 @@ func (cmd *Command) parseFlags(args []string) (retentionPolicy, shardID string,
 		}
 	}
 
+	if cmd.concurrency < 1 {
+		return "", "", time.Unix(0, 0), errors.New("concurrency must be at least 1")
+	} else if cmd.rateLimit < 0 {
+		return "", "", time.Unix(0, 0), errors.New("rate-limit must not be negative")
+	}
+
 	// Ensure that only one arg is specified.
 	if fs.NArg() == 0 {
 		return "", "", time.Unix(0, 0), errors.New("backup destination path required")
@@ -150,9 +180,26 @@ func (cmd *Command) backupShard(retentionPolicy string, shardID string, since ti
 		ShardID:         id,
 		Since:           since,
 	}
+	if cmd.compress {
+		req.Compression = snapshotter.CompressionGzip
+	}
 
-	// TODO: verify shard backup data
-	return cmd.downloadAndVerify(req, shardArchivePath, nil)
+	// Reading the manifest from the downloaded archive also validates it.
+	var manifest *Manifest
+	if err := cmd.downloadAndVerify(req, shardArchivePath, func(file string) error {
+		var err error
+		manifest, err = CreateManifest(file)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	// Nothing was downloaded so there is nothing to describe.
+	if len(manifest.Files) == 0 {
+		return nil
+	}
+
+	return WriteManifest(shardArchivePath, manifest)
 }
 
 // backupDatabase will request the database information from the server and then backup the metastore and
@@ -198,19 +245,50 @@ func (cmd *Command) backupResponsePaths(response *snapshotter.Response, since ti
 		return err
 	}
 
-	// loop through the returned paths and back up each shard
+	type shard struct {
+		rp, id string
+	}
+
+	shardC := make(chan shard, len(response.Paths))
 	for _, path := range response.Paths {
 		rp, id, err := retentionAndShardFromPath(path)
 		if err != nil {
 			return err
 		}
+		shardC <- shard{rp: rp, id: id}
+	}
+	close(shardC)
+
+	// Once a shard fails, shards that have not started yet are skipped.
+	var failed int32
+
+	// back up the shards in order using concurrency workers
+	errC := make(chan error, cmd.concurrency)
+	for i := 0; i < cmd.concurrency; i++ {
+		go func() {
+			for sh := range shardC {
+				if atomic.LoadInt32(&failed) != 0 {
+					break
+				}
+
+				if err := cmd.backupShard(sh.rp, sh.id, since); err != nil {
+					atomic.StoreInt32(&failed, 1)
+					errC <- err
+					return
+				}
+			}
+			errC <- nil
+		}()
+	}
 
-		if err := cmd.backupShard(rp, id, since); err != nil {
-			return err
+	// Wait for every worker so that no download is still running on return.
+	var err error
+	for i := 0; i < cmd.concurrency; i++ {
+		if e := <-errC; e != nil && err == nil {
+			err = e
 		}
 	}
-
-	return nil
+	return err
 }
 
 // backupMetastore will backup the metastore on the host to the passed in path. Database and retention policy backups
@@ -233,10 +311,9 @@ func (cmd *Command) backupMetastore() error {
 			return err
 		}
 
-		magic := binary.BigEndian.Uint64(binData[:8])
-		if magic != snapshotter.BackupMagicHeader {
+		if _, _, err := snapshotter.UnmarshalMetastoreBackup(binData); err != nil {
 			cmd.Logger.Println("Invalid metadata blob, ensure the metadata service is running (default port 8088)")
-			return errors.New("invalid metadata received")
+			return err
 		}
 
 		return nil
@@ -313,7 +390,11 @@ func (cmd *Command) download(req *snapshotter.Request, path string) error {
 	}
 
 	// Read snapshot from the connection
-	if _, err := io.Copy(f, conn); err != nil {
+	var r io.Reader = conn
+	if cmd.rateLimit > 0 {
+		r = limiter.NewReader(conn, cmd.rateLimit)
+	}
+	if _, err := io.Copy(f, r); err != nil {
 		return fmt.Errorf("copy backup to file: %s", err)
 	}
 
@@ -360,6 +441,28 @@ Usage: influxd backup [flags] PATH
     -since <2015-12-24T08:12:23>
             Optional. Do an incremental backup since the passed in RFC3339
             formatted time.
+    -compress
+            Optional. Request shard backups compressed with gzip. Servers
+            that do not support compression send uncompressed backups.
+    -concurrency <n>
+            Optional. The number of shards to back up at the same time when
+            backing up a database or retention policy. Defaults to 1.
+    -rate-limit <bytes>
+            Optional. The maximum number of bytes per second to download for
+            each shard being backed up. Defaults to 0, which is unlimited.
+    -pre-hook <command>
+            Optional. A shell command to run before the backup starts. The
+            backup is aborted if the command fails.
+    -post-hook <command>
+            Optional. A shell command to run after the backup completes. The
+            backup fails if the command fails.
+    -failure-hook <command>
+            Optional. A shell command to run if the backup fails.
+
+Hooks are passed the backup in the INFLUXDB_BACKUP_HOST, INFLUXDB_BACKUP_PATH,
+INFLUXDB_BACKUP_DATABASE, INFLUXDB_BACKUP_RETENTION, INFLUXDB_BACKUP_SHARD and
+INFLUXDB_BACKUP_SINCE environment variables. The failure hook is also passed
+the error in INFLUXDB_BACKUP_ERROR.
 
 `)
 }
@@ -0,0 +1,286 @@
+package backup_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/cmd/influxd/backup"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/services/snapshotter"
+	"github.com/influxdata/influxdb/tcp"
+	"github.com/influxdata/influxdb/tsdb"
+)
+
+// Ensure that a failing pre-backup hook aborts the backup.
+func TestCommand_Run_PreHookFailure(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "post")
+	cmd := NewCommand()
+	err := cmd.Run("-host", "127.0.0.1:1", "-pre-hook", "exit 1", "-post-hook", "touch "+out, filepath.Join(dir, "backup"))
+	if err == nil || !strings.Contains(err.Error(), "pre-backup hook") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatal("post-backup hook ran")
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "backup", "*")); len(matches) != 0 {
+		t.Fatalf("unexpected backup files: %v", matches)
+	}
+}
+
+// Ensure that the failure hook is passed the backup and its error.
+func TestCommand_Run_FailureHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook command uses sh syntax")
+	}
+
+	for _, args := range [][]string{
+		{"-database", "mydb"},
+		{"-database", "mydb", "-retention", "rp", "-shard", "1"},
+	} {
+		dir := MustTempDir()
+		defer os.RemoveAll(dir)
+
+		out := filepath.Join(dir, "failure")
+		args = append(args,
+			"-host", "127.0.0.1:1",
+			"-failure-hook", `echo "$INFLUXDB_BACKUP_DATABASE: $INFLUXDB_BACKUP_ERROR" > `+out,
+			filepath.Join(dir, "backup"),
+		)
+		if err := NewCommand().Run(args...); err == nil {
+			t.Fatalf("%v: expected error", args)
+		}
+
+		b, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatalf("%v: %s", args, err)
+		} else if !strings.HasPrefix(string(b), "mydb: ") || len(b) <= len("mydb: \n") {
+			t.Fatalf("%v: unexpected hook output: %q", args, b)
+		}
+	}
+}
+
+// Ensure that a database backup with concurrency backs up every shard, using
+// more than one worker.
+func TestCommand_Run_Concurrency(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	s := MustOpenSnapshotter(8)
+	defer s.Close()
+
+	var active, maxActive int32
+	s.TSDBStore.BackupShardFn = func(id uint64, since time.Time, w io.Writer) error {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			max := atomic.LoadInt32(&maxActive)
+			if n <= max || atomic.CompareAndSwapInt32(&maxActive, max, n) {
+				break
+			}
+		}
+
+		// Give other workers time to start their backups.
+		time.Sleep(20 * time.Millisecond)
+		return WriteShardArchive(w, id)
+	}
+
+	if err := NewCommand().Run("-host", s.Addr(), "-database", "mydb", "-concurrency", "4", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for id := 1; id <= 8; id++ {
+		path := filepath.Join(dir, fmt.Sprintf("mydb.rp.%05d.00", id))
+		if _, err := os.Stat(path); err != nil {
+			t.Fatal(err)
+		} else if _, err := os.Stat(path + backup.ManifestSuffix); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if maxActive < 2 {
+		t.Fatalf("shards were not backed up concurrently: max active %d", maxActive)
+	}
+}
+
+// Ensure that workers stop taking shards once a shard backup fails and that
+// the first error is returned.
+func TestCommand_Run_Concurrency_Failure(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	s := MustOpenSnapshotter(8)
+	defer s.Close()
+
+	var mu sync.Mutex
+	var requested []uint64
+	s.TSDBStore.BackupShardFn = func(id uint64, since time.Time, w io.Writer) error {
+		mu.Lock()
+		requested = append(requested, id)
+		mu.Unlock()
+
+		switch id {
+		case 1:
+			// Fail immediately with data that is not a tar archive.
+			_, err := w.Write(bytes.Repeat([]byte("x"), 1024))
+			return err
+		case 2:
+			// Fail after shard 1 with a truncated archive.
+			time.Sleep(100 * time.Millisecond)
+			tw := tar.NewWriter(w)
+			if err := tw.WriteHeader(&tar.Header{Name: "mydb/rp/2/000000001-000000001.tsm", Mode: 0666, Size: 100}); err != nil {
+				return err
+			}
+			return tw.Flush()
+		}
+		return WriteShardArchive(w, id)
+	}
+
+	err := NewCommand().Run("-host", s.Addr(), "-database", "mydb", "-concurrency", "2", dir)
+	if err != tar.ErrHeader {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Sort(uint64Slice(requested))
+	if !reflect.DeepEqual(requested, []uint64{1, 2}) {
+		t.Fatalf("unexpected shards requested: %v", requested)
+	}
+}
+
+// NewCommand returns a backup command that discards its output.
+func NewCommand() *backup.Command {
+	cmd := backup.NewCommand()
+	cmd.Stdout = &bytes.Buffer{}
+	cmd.Stderr = &bytes.Buffer{}
+	return cmd
+}
+
+// Snapshotter is a snapshotter service that serves a database of mocked shards.
+type Snapshotter struct {
+	*snapshotter.Service
+	TSDBStore TSDBStore
+	ln        net.Listener
+}
+
+// MustOpenSnapshotter returns an open snapshotter service for the database mydb
+// with n shards in the retention policy rp. Panic on error.
+func MustOpenSnapshotter(n int) *Snapshotter {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	mux := tcp.NewMux()
+	go mux.Serve(ln)
+
+	db := &meta.DatabaseInfo{
+		Name:              "mydb",
+		RetentionPolicies: []meta.RetentionPolicyInfo{{Name: "rp", ShardGroups: []meta.ShardGroupInfo{{}}}},
+	}
+	for id := 1; id <= n; id++ {
+		sg := &db.RetentionPolicies[0].ShardGroups[0]
+		sg.Shards = append(sg.Shards, meta.ShardInfo{ID: uint64(id)})
+	}
+
+	s := &Snapshotter{Service: snapshotter.NewService(snapshotter.NewConfig()), ln: ln}
+	s.Listener = mux.Listen(snapshotter.MuxHeader)
+	s.MetaClient = &MetaClient{Data: db}
+	s.Service.TSDBStore = &s.TSDBStore
+	s.SetLogOutput(ioutil.Discard)
+	if err := s.Open(); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Addr returns the address of the service.
+func (s *Snapshotter) Addr() string { return s.ln.Addr().String() }
+
+// Close closes the listener and the service.
+func (s *Snapshotter) Close() error {
+	s.ln.Close()
+	return s.Service.Close()
+}
+
+// MetaClient is a mock implementation of the snapshotter meta client.
+type MetaClient struct {
+	Data *meta.DatabaseInfo
+}
+
+func (c *MetaClient) MarshalBinary() ([]byte, error) {
+	var data meta.Data
+	return data.MarshalBinary()
+}
+
+func (c *MetaClient) Database(name string) *meta.DatabaseInfo {
+	if name != c.Data.Name {
+		return nil
+	}
+	return c.Data
+}
+
+// TSDBStore is a mock implementation of the snapshotter TSDB store. Every
+// shard of the mocked database is local.
+type TSDBStore struct {
+	BackupShardFn func(id uint64, since time.Time, w io.Writer) error
+}
+
+func (s *TSDBStore) BackupShard(id uint64, since time.Time, w io.Writer) error {
+	return s.BackupShardFn(id, since, w)
+}
+
+func (s *TSDBStore) Shard(id uint64) *tsdb.Shard { return &tsdb.Shard{} }
+
+func (s *TSDBStore) ShardRelativePath(id uint64) (string, error) {
+	return filepath.Join("mydb", "rp", strconv.FormatUint(id, 10)), nil
+}
+
+// WriteShardArchive writes a shard backup with a single file to w.
+func WriteShardArchive(w io.Writer, id uint64) error {
+	data := []byte("tsm data")
+	tw := tar.NewWriter(w)
+	if err := tw.WriteHeader(&tar.Header{
+		Name: fmt.Sprintf("mydb/rp/%d/000000001-000000001.tsm", id),
+		Mode: 0666,
+		Size: int64(len(data)),
+	}); err != nil {
+		return err
+	} else if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+type uint64Slice []uint64
+
+func (a uint64Slice) Len() int           { return len(a) }
+func (a uint64Slice) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a uint64Slice) Less(i, j int) bool { return a[i] < a[j] }
+
+// MustTempDir returns a new temporary directory. Panic on error.
+func MustTempDir() string {
+	dir, err := ioutil.TempDir("", "influxd-backup-")
+	if err != nil {
+		panic(err)
+	}
+	return dir
+}
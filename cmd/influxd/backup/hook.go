@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// hookEnv returns the environment passed to hooks, which describes the backup
+// being taken. Empty values are omitted.
+func (cmd *Command) hookEnv(retentionPolicy, shardID string, since time.Time, err error) []string {
+	env := os.Environ()
+	add := func(name, value string) {
+		if value != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+
+	add("INFLUXDB_BACKUP_HOST", cmd.host)
+	add("INFLUXDB_BACKUP_PATH", cmd.path)
+	add("INFLUXDB_BACKUP_DATABASE", cmd.database)
+	add("INFLUXDB_BACKUP_RETENTION", retentionPolicy)
+	add("INFLUXDB_BACKUP_SHARD", shardID)
+	if !since.IsZero() {
+		add("INFLUXDB_BACKUP_SINCE", since.Format(time.RFC3339))
+	}
+	if err != nil {
+		add("INFLUXDB_BACKUP_ERROR", err.Error())
+	}
+	return env
+}
+
+// runHook runs command with the shell, if it is set. The output of the command
+// is written to the command's standard output and error.
+func (cmd *Command) runHook(name, command string, env []string) error {
+	if command == "" {
+		return nil
+	}
+
+	cmd.Logger.Printf("running %s hook", name)
+
+	var c *exec.Cmd
+	if runtime.GOOS == "windows" {
+		c = exec.Command("cmd", "/C", command)
+	} else {
+		c = exec.Command("/bin/sh", "-c", command)
+	}
+	c.Env = env
+	c.Stdout = cmd.Stdout
+	c.Stderr = cmd.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("%s hook: %s", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// ManifestSuffix is the suffix of the manifest written next to each shard backup.
+const ManifestSuffix = ".manifest"
+
+// ManifestVersion is the version of the shard backup format written by this
+// build. It must be incremented whenever a change to the backup format would
+// cause older builds to restore a backup incorrectly.
+const ManifestVersion = 1
+
+// Manifest describes the files in a shard backup so that a restore can verify
+// them and skip files that were already restored by an interrupted restore.
+type Manifest struct {
+	// Version is the backup format version. Manifests written before the
+	// version was recorded have a version of zero and are treated as version 1.
+	Version int            `json:"version,omitempty"`
+	Files   []ManifestFile `json:"files"`
+}
+
+// ManifestFile describes a single file in a shard backup.
+type ManifestFile struct {
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256"`
+	Generation int    `json:"generation,omitempty"`
+}
+
+// File returns the manifest entry for the named archive file or nil if there is none.
+func (m *Manifest) File(name string) *ManifestFile {
+	for i := range m.Files {
+		if m.Files[i].Name == name {
+			return &m.Files[i]
+		}
+	}
+	return nil
+}
+
+// Matches returns true if the file at path has the size and checksum of f.
+func (f *ManifestFile) Matches(path string) (bool, error) {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	} else if fi.Size() != f.Size {
+		return false, nil
+	}
+
+	fr, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer fr.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, fr); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)) == f.SHA256, nil
+}
+
+// NewArchiveReader returns a tar reader for the shard backup read from r.
+// Backups that were compressed with gzip are decompressed transparently.
+func NewArchiveReader(r io.Reader) (*tar.Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return tar.NewReader(gr), nil
+	}
+	return tar.NewReader(br), nil
+}
+
+// CreateManifest reads the shard backup at path and returns a manifest of its files.
+func CreateManifest(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tr, err := NewArchiveReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{Version: ManifestVersion}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return m, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		h := sha256.New()
+		if _, err := io.CopyN(h, tr, hdr.Size); err != nil {
+			return nil, err
+		}
+
+		mf := ManifestFile{
+			Name:   hdr.Name,
+			Size:   hdr.Size,
+			SHA256: hex.EncodeToString(h.Sum(nil)),
+		}
+		if generation, _, err := tsm1.ParseTSMFileName(hdr.Name); err == nil {
+			mf.Generation = generation
+		}
+		m.Files = append(m.Files, mf)
+	}
+}
+
+// ReadManifest reads the manifest of the shard backup at path. A nil manifest is
+// returned for backups that were taken before manifests were written.
+func ReadManifest(path string) (*Manifest, error) {
+	b, err := ioutil.ReadFile(path + ManifestSuffix)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("unmarshal manifest: %s", err)
+	} else if m.Version > ManifestVersion {
+		return nil, fmt.Errorf("backup format version %d is newer than the supported version %d, restore with a newer build of influxd", m.Version, ManifestVersion)
+	}
+	return &m, nil
+}
+
+// WriteManifest writes the manifest of the shard backup at path.
+func WriteManifest(path string, m *Manifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	tmppath := path + ManifestSuffix + Suffix
+	if err := ioutil.WriteFile(tmppath, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmppath, path+ManifestSuffix)
+}
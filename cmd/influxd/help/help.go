@@ -37,6 +37,7 @@ The commands are:
     help                 display this help message
     restore              uses a snapshot of a data node to rebuild a cluster
     run                  run node with existing configuration
+    verify-backup        checks that backups can be restored
     version              displays the InfluxDB version
 
 "run" is the default command.
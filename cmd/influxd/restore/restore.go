@@ -3,23 +3,37 @@ package restore
 import (
 	"archive/tar"
 	"bytes"
-	"encoding/binary"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/influxdata/influxdb/cmd/influxd/backup"
+	"github.com/influxdata/influxdb/pkg/limiter"
 	"github.com/influxdata/influxdb/services/meta"
 	"github.com/influxdata/influxdb/services/snapshotter"
+	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
 )
 
+// tombstoneFileExtension is the extension of the tombstone file of a TSM file.
+const tombstoneFileExtension = "tombstone"
+
+// ErrRestoreLimitExceeded is returned when a backup has more files or bytes
+// than the -max-files or -max-bytes limits of the restore allow.
+var ErrRestoreLimitExceeded = errors.New("restore limit exceeded")
+
 // Command represents the program execution for "influxd restore".
 type Command struct {
 	Stdout io.Writer
@@ -31,6 +45,18 @@ type Command struct {
 	database        string
 	retention       string
 	shard           string
+	rateLimit       int
+
+	// Limits on the files and bytes unpacked by the restore, 0 is unlimited.
+	maxFiles int
+	maxBytes int64
+	files    int
+	bytes    int64
+
+	// Only restore TSM data of the measurement between start and end.
+	measurement string
+	start       int64
+	end         int64
 
 	// TODO: when the new meta stuff is done this should not be exported or be gone
 	MetaConfig *meta.Config
@@ -75,12 +101,35 @@ func (cmd *Command) parseFlags(args []string) error {
 	fs.StringVar(&cmd.database, "database", "", "")
 	fs.StringVar(&cmd.retention, "retention", "", "")
 	fs.StringVar(&cmd.shard, "shard", "", "")
+	fs.IntVar(&cmd.rateLimit, "rate-limit", 0, "")
+	fs.IntVar(&cmd.maxFiles, "max-files", 0, "")
+	fs.Int64Var(&cmd.maxBytes, "max-bytes", 0, "")
+	fs.StringVar(&cmd.measurement, "measurement", "", "")
+	var startArg, endArg string
+	fs.StringVar(&startArg, "start", "", "")
+	fs.StringVar(&endArg, "end", "", "")
 	fs.SetOutput(cmd.Stdout)
 	fs.Usage = cmd.printUsage
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 
+	cmd.start, cmd.end = math.MinInt64, math.MaxInt64
+	if startArg != "" {
+		t, err := time.Parse(time.RFC3339, startArg)
+		if err != nil {
+			return err
+		}
+		cmd.start = t.UnixNano()
+	}
+	if endArg != "" {
+		t, err := time.Parse(time.RFC3339, endArg)
+		if err != nil {
+			return err
+		}
+		cmd.end = t.UnixNano()
+	}
+
 	cmd.MetaConfig = meta.NewConfig()
 	cmd.MetaConfig.Dir = cmd.metadir
 
@@ -99,6 +148,20 @@ func (cmd *Command) parseFlags(args []string) error {
 		return fmt.Errorf("-datadir is required to restore")
 	}
 
+	if cmd.rateLimit < 0 {
+		return fmt.Errorf("-rate-limit must not be negative")
+	}
+
+	if cmd.maxFiles < 0 || cmd.maxBytes < 0 {
+		return fmt.Errorf("-max-files and -max-bytes must not be negative")
+	}
+
+	if cmd.filtered() && cmd.database == "" {
+		return fmt.Errorf("-database is required to filter a restore")
+	} else if cmd.end < cmd.start {
+		return fmt.Errorf("-end must not be before -start")
+	}
+
 	if cmd.shard != "" {
 		if cmd.database == "" {
 			return fmt.Errorf("-database is required to restore shard")
@@ -140,26 +203,11 @@ func (cmd *Command) unpackMeta() error {
 		return fmt.Errorf("copy: %s", err)
 	}
 
-	b := buf.Bytes()
-	var i int
-
 	// Make sure the file is actually a meta store backup file
-	magic := binary.BigEndian.Uint64(b[:8])
-	if magic != snapshotter.BackupMagicHeader {
-		return fmt.Errorf("invalid metadata file")
+	metaBytes, nodeBytes, err := snapshotter.UnmarshalMetastoreBackup(buf.Bytes())
+	if err != nil {
+		return err
 	}
-	i += 8
-
-	// Size of the meta store bytes
-	length := int(binary.BigEndian.Uint64(b[i : i+8]))
-	i += 8
-	metaBytes := b[i : i+length]
-	i += int(length)
-
-	// Size of the node.json bytes
-	length = int(binary.BigEndian.Uint64(b[i : i+8]))
-	i += 8
-	nodeBytes := b[i:]
 
 	// Unpack into metadata.
 	var data meta.Data
@@ -274,8 +322,21 @@ func (cmd *Command) unpackFiles(pat string) error {
 		return fmt.Errorf("no backup files for %s in %s", pat, cmd.backupFilesPath)
 	}
 
+	// The TSM files that were dropped by the filter, without their extension.
+	// Their tombstones must not be restored, or they would apply to the next
+	// TSM file the engine writes with the same name. An incremental backup may
+	// contain the tombstone of a TSM file from an earlier backup, so this
+	// covers every backup being restored.
+	dropped := make(map[string]struct{})
+
 	for _, fn := range backupFiles {
-		if err := cmd.unpackTar(fn); err != nil {
+		// Manifests are read along with the backup they describe, and backups
+		// that were not completely downloaded are skipped.
+		if strings.HasSuffix(fn, backup.ManifestSuffix) || strings.HasSuffix(fn, backup.Suffix) {
+			continue
+		}
+
+		if err := cmd.unpackTar(fn, dropped); err != nil {
 			return err
 		}
 	}
@@ -283,50 +344,258 @@ func (cmd *Command) unpackFiles(pat string) error {
 	return nil
 }
 
-// unpackTar will restore a single tar archive to the data dir
-func (cmd *Command) unpackTar(tarFile string) error {
+// unpackTar will restore a single tar archive to the data dir. If the archive has
+// a manifest, every file is verified against it. TSM files dropped by the
+// filter are added to dropped, and the tombstones of dropped files are skipped.
+func (cmd *Command) unpackTar(tarFile string, dropped map[string]struct{}) error {
+	manifest, err := backup.ReadManifest(tarFile)
+	if err != nil {
+		return err
+	}
+
 	f, err := os.Open(tarFile)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	tr := tar.NewReader(f)
+	var r io.Reader = f
+	if cmd.rateLimit > 0 {
+		r = limiter.NewReader(f, cmd.rateLimit)
+	}
 
+	tr, err := backup.NewArchiveReader(r)
+	if err != nil {
+		return err
+	}
+
+	var n int
 	for {
 		hdr, err := tr.Next()
 		if err == io.EOF {
-			return nil
+			break
 		} else if err != nil {
 			return err
 		}
 
-		if err := cmd.unpackFile(tr, hdr.Name); err != nil {
+		var mf *backup.ManifestFile
+		if manifest != nil {
+			if mf = manifest.File(hdr.Name); mf == nil {
+				return fmt.Errorf("file not in backup manifest: %s", hdr.Name)
+			}
+		}
+
+		n++
+
+		// Check the limits before the file is unpacked. The tar reader never
+		// returns more than the declared size of an entry, so this also bounds
+		// the bytes a compressed backup can decompress to.
+		if err := cmd.checkLimits(hdr); err != nil {
 			return err
 		}
+
+		base, ext := fileBase(hdr.Name)
+		if _, ok := dropped[base]; ok && ext == "."+tombstoneFileExtension {
+			fmt.Printf("skipping %s, TSM file has no matching data\n", hdr.Name)
+			continue
+		}
+
+		if ok, err := cmd.unpackFile(tr, hdr.Name, mf); err != nil {
+			return err
+		} else if !ok {
+			// Remove the tombstone if it was restored before its TSM file.
+			dropped[base] = struct{}{}
+			tombstone := filepath.Join(cmd.datadir, base+"."+tombstoneFileExtension)
+			if err := os.Remove(tombstone); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	if manifest != nil && n != len(manifest.Files) {
+		return fmt.Errorf("backup is missing files: %s: exp %d, got %d", tarFile, len(manifest.Files), n)
 	}
+	return nil
 }
 
-// unpackFile will copy the current file from the tar archive to the data dir
-func (cmd *Command) unpackFile(tr *tar.Reader, fileName string) error {
+// checkLimits adds the archive entry to the files and bytes unpacked by the
+// restore, and returns ErrRestoreLimitExceeded if either limit is exceeded.
+func (cmd *Command) checkLimits(hdr *tar.Header) error {
+	cmd.files++
+	cmd.bytes += hdr.Size
+	if cmd.maxFiles > 0 && cmd.files > cmd.maxFiles {
+		fmt.Printf("%s exceeds the limit of %d files\n", hdr.Name, cmd.maxFiles)
+		return ErrRestoreLimitExceeded
+	} else if cmd.maxBytes > 0 && cmd.bytes > cmd.maxBytes {
+		fmt.Printf("%s exceeds the limit of %d bytes\n", hdr.Name, cmd.maxBytes)
+		return ErrRestoreLimitExceeded
+	}
+	return nil
+}
+
+// isSubPath returns true if the relative path rel is below its base directory.
+func isSubPath(rel string) bool {
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)) && !filepath.IsAbs(rel)
+}
+
+// fileBase returns the name of an archive file without its extension, and the extension.
+func fileBase(name string) (string, string) {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext), ext
+}
+
+// unpackFile will copy the current file from the tar archive to the data dir.
+// If mf is not nil the file is checked against it before being moved into place,
+// and files that already match it from an earlier restore are skipped. Returns
+// false if the file is a TSM file that was dropped because no data matched the filter.
+func (cmd *Command) unpackFile(tr *tar.Reader, fileName string, mf *backup.ManifestFile) (bool, error) {
 	fn := filepath.Join(cmd.datadir, fileName)
+
+	// Ensure the archive cannot write files outside of the data dir.
+	if rel, err := filepath.Rel(cmd.datadir, fn); err != nil || !isSubPath(rel) {
+		return false, fmt.Errorf("invalid archive path: %s", fileName)
+	}
+
+	// A filtered file never matches the manifest so it is always restored again.
+	if mf != nil && !cmd.filtered() {
+		if ok, err := mf.Matches(fn); err != nil {
+			return false, err
+		} else if ok {
+			fmt.Printf("skipping %s, already restored\n", fn)
+			return true, nil
+		}
+	}
 	fmt.Printf("unpacking %s\n", fn)
 
 	if err := os.MkdirAll(filepath.Dir(fn), 0777); err != nil {
-		return fmt.Errorf("error making restore dir: %s", err.Error())
+		return false, fmt.Errorf("error making restore dir: %s", err.Error())
 	}
 
-	ff, err := os.Create(fn)
+	tmp := fn + ".tmp"
+	ff, err := os.Create(tmp)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer ff.Close()
 
-	if _, err := io.Copy(ff, tr); err != nil {
+	// Remove the temporary file if it is not moved into place.
+	defer os.Remove(tmp)
+
+	var w io.Writer = ff
+	h := sha256.New()
+	if mf != nil {
+		w = io.MultiWriter(ff, h)
+	}
+
+	if _, err := io.Copy(w, tr); err != nil {
+		return false, err
+	}
+
+	if err := ff.Close(); err != nil {
+		return false, err
+	}
+
+	if mf != nil && hex.EncodeToString(h.Sum(nil)) != mf.SHA256 {
+		return false, fmt.Errorf("checksum mismatch: %s", fileName)
+	}
+
+	if cmd.filtered() && filepath.Ext(fn) == "."+tsm1.TSMFileExtension {
+		src := tmp
+		tmp = fn + ".filtered.tmp"
+		defer os.Remove(tmp)
+
+		if err := cmd.filterTSM(src, tmp); err == tsm1.ErrNoValues {
+			fmt.Printf("skipping %s, no matching data\n", fn)
+			return false, nil
+		} else if err != nil {
+			return false, fmt.Errorf("filter %s: %s", fileName, err)
+		}
+	}
+
+	return true, os.Rename(tmp, fn)
+}
+
+// filtered returns true if only some of the TSM data should be restored.
+func (cmd *Command) filtered() bool {
+	return cmd.measurement != "" || cmd.start != math.MinInt64 || cmd.end != math.MaxInt64
+}
+
+// filterTSM writes the blocks of the TSM file at src that match the measurement
+// and time range to a new TSM file at dst. Blocks that are only partially within
+// the time range are rewritten with the values that are within it. Returns
+// tsm1.ErrNoValues if no blocks matched.
+func (cmd *Command) filterTSM(src, dst string) (err error) {
+	f, err := os.Open(src)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	r, err := tsm1.NewTSMReader(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	defer r.Close()
+
+	fd, err := os.OpenFile(dst, os.O_CREATE|os.O_RDWR|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+
+	w, err := tsm1.NewTSMWriter(fd)
+	if err != nil {
+		fd.Close()
+		return err
+	}
+	defer func() {
+		closeErr := w.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+
+	itr := r.BlockIterator()
+	for itr.Next() {
+		key, minTime, maxTime, _, block, err := itr.Read()
+		if err != nil {
+			return err
+		}
+
+		if maxTime < cmd.start || minTime > cmd.end {
+			continue
+		}
+		if cmd.measurement != "" {
+			seriesKey, _ := tsm1.SeriesAndFieldFromCompositeKey([]byte(key))
+			if tsdb.MeasurementFromSeriesKey(string(seriesKey)) != cmd.measurement {
+				continue
+			}
+		}
+
+		// Copy blocks that are entirely within the time range as they are.
+		if minTime >= cmd.start && maxTime <= cmd.end {
+			if err := w.WriteBlock(key, minTime, maxTime, block); err != nil {
+				return err
+			}
+			continue
+		}
+
+		values, err := tsm1.DecodeBlock(block, nil)
+		if err != nil {
+			return err
+		}
+
+		filtered := values[:0]
+		for _, v := range values {
+			if v.UnixNano() >= cmd.start && v.UnixNano() <= cmd.end {
+				filtered = append(filtered, v)
+			}
+		}
+		if err := w.Write(key, filtered); err != nil {
+			return err
+		}
+	}
+
+	return w.WriteIndex()
 }
 
 // printUsage prints the usage message to STDERR.
@@ -351,6 +620,25 @@ Usage: influxd restore [flags] PATH
     -shard <id>
             Optional. If given, database and retention are required. Will restore the shard's
             TSM files.
+    -rate-limit <bytes>
+            Optional. The maximum number of bytes per second to read from the
+            backup files. Defaults to 0, which is unlimited.
+    -max-files <n>
+            Optional. The maximum number of files to restore. The restore stops
+            with an error before unpacking more files. Defaults to 0, which is unlimited.
+    -max-bytes <bytes>
+            Optional. The maximum number of bytes to restore. The restore stops
+            with an error before unpacking a file that would exceed it. Defaults
+            to 0, which is unlimited.
+    -measurement <name>
+            Optional. If given, database is required. Only restore the TSM data
+            of the measurement.
+    -start <2015-12-24T08:12:23>
+            Optional. If given, database is required. Only restore the TSM data
+            at or after the passed in RFC3339 formatted time.
+    -end <2015-12-24T08:12:23>
+            Optional. If given, database is required. Only restore the TSM data
+            at or before the passed in RFC3339 formatted time.
 
 `)
 }
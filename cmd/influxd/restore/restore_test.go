@@ -0,0 +1,575 @@
+package restore_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/cmd/influxd/backup"
+	"github.com/influxdata/influxdb/cmd/influxd/restore"
+	"github.com/influxdata/influxdb/services/snapshotter"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// Ensure that restore decompresses shard backups compressed with gzip.
+func TestCommand_Run_Gzip(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	path := MustWriteShardBackup(filepath.Join(dir, "backup"), map[string]string{
+		"mydb/rp/1/000000001-000000001.tsm": "tsm data",
+	})
+
+	MustGzipFile(path)
+
+	datadir := filepath.Join(dir, "data")
+	if err := restore.NewCommand().Run("-database", "mydb", "-datadir", datadir, filepath.Join(dir, "backup")); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(datadir, "mydb", "rp", "1", "000000001-000000001.tsm")); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "tsm data" {
+		t.Fatalf("unexpected file contents: %q", b)
+	}
+}
+
+// Ensure that restore rejects a shard backup that does not match its manifest.
+func TestCommand_Run_ChecksumMismatch(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	path := MustWriteShardBackup(filepath.Join(dir, "backup"), map[string]string{
+		"mydb/rp/1/000000001-000000001.tsm": "tsm data",
+	})
+
+	// Corrupt the first byte of the file data, which follows its header.
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[512] ^= 0xff
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	datadir := filepath.Join(dir, "data")
+	cmd := restore.NewCommand()
+	if err := cmd.Run("-database", "mydb", "-datadir", datadir, filepath.Join(dir, "backup")); err == nil {
+		t.Fatal("expected checksum error")
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(datadir, "mydb", "rp", "1", "*"))
+	if len(matches) != 0 {
+		t.Fatalf("unexpected restored files: %v", matches)
+	}
+}
+
+// Ensure that restore rejects a metastore backup written in a newer format.
+func TestCommand_Run_Meta_NewerVersion(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	var b [32]byte
+	binary.BigEndian.PutUint64(b[:8], snapshotter.BackupMagicHeaderVersioned)
+	binary.BigEndian.PutUint64(b[8:16], snapshotter.MetastoreBackupVersion+1)
+	if err := os.MkdirAll(filepath.Join(dir, "backup"), 0777); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(filepath.Join(dir, "backup", backup.Metafile+".00"), b[:], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := restore.NewCommand()
+	cmd.Stdout = ioutil.Discard
+	err := cmd.Run("-metadir", filepath.Join(dir, "meta"), filepath.Join(dir, "backup"))
+	if err == nil || !strings.Contains(err.Error(), "newer than the supported version") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that restore removes the partially unpacked file of a truncated backup.
+func TestCommand_Run_Truncated(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	path := MustWriteShardBackup(filepath.Join(dir, "backup"), map[string]string{
+		"mydb/rp/1/000000001-000000001.tsm": "tsm data",
+	})
+
+	// Cut the archive off in the middle of the file data.
+	if err := os.Truncate(path, 512+4); err != nil {
+		t.Fatal(err)
+	}
+
+	datadir := filepath.Join(dir, "data")
+	if err := restore.NewCommand().Run("-database", "mydb", "-datadir", datadir, filepath.Join(dir, "backup")); err == nil {
+		t.Fatal("expected error")
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(datadir, "mydb", "rp", "1", "*"))
+	if len(matches) != 0 {
+		t.Fatalf("unexpected restored files: %v", matches)
+	}
+}
+
+// Ensure that restore skips backups that were not completely downloaded.
+func TestCommand_Run_Pending(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	MustWriteShardBackup(filepath.Join(dir, "backup"), map[string]string{
+		"mydb/rp/1/000000001-000000001.tsm": "tsm data",
+	})
+	for _, name := range []string{"mydb.rp.00001.01" + backup.Suffix, "mydb.rp.00001.01" + backup.ManifestSuffix + backup.Suffix} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "backup", name), []byte("partial"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	datadir := filepath.Join(dir, "data")
+	if err := restore.NewCommand().Run("-database", "mydb", "-datadir", datadir, filepath.Join(dir, "backup")); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(datadir, "mydb", "rp", "1", "*"))
+	if exp := []string{filepath.Join(datadir, "mydb", "rp", "1", "000000001-000000001.tsm")}; !reflect.DeepEqual(matches, exp) {
+		t.Fatalf("unexpected restored files: %v", matches)
+	}
+}
+
+// Ensure that restore rejects a backup written in a newer backup format.
+func TestCommand_Run_NewerVersion(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	path := MustWriteShardBackup(filepath.Join(dir, "backup"), map[string]string{
+		"mydb/rp/1/000000001-000000001.tsm": "tsm data",
+	})
+
+	m, err := backup.ReadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Version = backup.ManifestVersion + 1
+	if err := backup.WriteManifest(path, m); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := restore.NewCommand()
+	err = cmd.Run("-database", "mydb", "-datadir", filepath.Join(dir, "data"), filepath.Join(dir, "backup"))
+	if err == nil || !strings.Contains(err.Error(), "backup format version") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that restore accepts a data dir relative to the working directory
+// and rejects archive paths outside of it.
+func TestCommand_Run_RelativeDatadir(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	MustWriteShardBackup(filepath.Join(dir, "backup"), map[string]string{
+		"mydb/rp/1/000000001-000000001.tsm": "tsm data",
+	})
+	for _, datadir := range []string{".", "./", "data", "./data/"} {
+		if err := restore.NewCommand().Run("-database", "mydb", "-datadir", datadir, filepath.Join(dir, "backup")); err != nil {
+			t.Fatalf("%s: %s", datadir, err)
+		}
+		if b, err := ioutil.ReadFile(filepath.Join(dir, datadir, "mydb", "rp", "1", "000000001-000000001.tsm")); err != nil {
+			t.Fatalf("%s: %s", datadir, err)
+		} else if string(b) != "tsm data" {
+			t.Fatalf("%s: unexpected file contents: %q", datadir, b)
+		}
+		os.RemoveAll(filepath.Join(dir, datadir, "mydb"))
+	}
+
+	MustWriteShardBackup(filepath.Join(dir, "evil"), map[string]string{
+		"mydb/rp/../../../evil": "evil",
+	})
+	if err := restore.NewCommand().Run("-database", "mydb", "-datadir", "data", filepath.Join(dir, "evil")); err == nil || !strings.Contains(err.Error(), "invalid archive path") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil.tmp")); !os.IsNotExist(err) {
+		t.Fatal("file written outside of data dir")
+	}
+}
+
+// Ensure that restore skips files that were restored by an earlier restore.
+func TestCommand_Run_Resume(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	MustWriteShardBackup(filepath.Join(dir, "backup"), map[string]string{
+		"mydb/rp/1/000000001-000000001.tsm": "first tsm",
+		"mydb/rp/1/000000002-000000001.tsm": "second tsm",
+	})
+
+	datadir := filepath.Join(dir, "data")
+	args := []string{"-database", "mydb", "-datadir", datadir, filepath.Join(dir, "backup")}
+	if err := restore.NewCommand().Run(args...); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate an interrupted restore that only restored the first file.
+	first := filepath.Join(datadir, "mydb", "rp", "1", "000000001-000000001.tsm")
+	second := filepath.Join(datadir, "mydb", "rp", "1", "000000002-000000001.tsm")
+	mtime := time.Unix(0, 0)
+	if err := os.Chtimes(first, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restore.NewCommand().Run(args...); err != nil {
+		t.Fatal(err)
+	}
+
+	if fi, err := os.Stat(first); err != nil {
+		t.Fatal(err)
+	} else if !fi.ModTime().Equal(mtime) {
+		t.Fatalf("restored file was rewritten: %s", first)
+	}
+
+	if b, err := ioutil.ReadFile(second); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "second tsm" {
+		t.Fatalf("unexpected file contents: %q", b)
+	}
+}
+
+// Ensure that a filtered restore only restores the matching TSM data.
+func TestCommand_Run_Filter(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	MustWriteShardBackup(filepath.Join(dir, "backup"), map[string]string{
+		"mydb/rp/1/000000001-000000001.tsm": MustTSMFile(map[string][]tsm1.Value{
+			"cpu,host=a#!~#value": {
+				tsm1.NewValue(0, 1.0),
+				tsm1.NewValue(int64(time.Second), 2.0),
+				tsm1.NewValue(int64(2*time.Second), 3.0),
+				tsm1.NewValue(int64(3*time.Second), 4.0),
+			},
+			"cpu,host=b#!~#value": {
+				tsm1.NewValue(int64(time.Second), 5.0),
+				tsm1.NewValue(int64(2*time.Second), 6.0),
+			},
+			"mem#!~#value": {
+				tsm1.NewValue(int64(time.Second), 7.0),
+			},
+		}),
+	})
+
+	datadir := filepath.Join(dir, "data")
+	if err := restore.NewCommand().Run(
+		"-database", "mydb", "-datadir", datadir,
+		"-measurement", "cpu", "-start", "1970-01-01T00:00:01Z", "-end", "1970-01-01T00:00:02Z",
+		filepath.Join(dir, "backup"),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(datadir, "mydb", "rp", "1", "000000001-000000001.tsm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := tsm1.NewTSMReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if n := r.KeyCount(); n != 2 {
+		t.Fatalf("unexpected key count: exp 2, got %d", n)
+	}
+	for key, exp := range map[string][]float64{
+		"cpu,host=a#!~#value": {2.0, 3.0},
+		"cpu,host=b#!~#value": {5.0, 6.0},
+	} {
+		values, err := r.ReadAll(key)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(values) != len(exp) {
+			t.Fatalf("unexpected values for %s: %v", key, values)
+		}
+		for i := range values {
+			if values[i].Value() != exp[i] {
+				t.Fatalf("unexpected values for %s: %v", key, values)
+			}
+		}
+	}
+}
+
+// Ensure that a filtered restore skips TSM files without matching data.
+func TestCommand_Run_Filter_NoMatch(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	MustWriteShardBackup(filepath.Join(dir, "backup"), map[string]string{
+		"mydb/rp/1/000000001-000000001.tsm": MustTSMFile(map[string][]tsm1.Value{
+			"mem#!~#value": {tsm1.NewValue(0, 1.0)},
+		}),
+	})
+
+	datadir := filepath.Join(dir, "data")
+	if err := restore.NewCommand().Run("-database", "mydb", "-datadir", datadir, "-measurement", "cpu", filepath.Join(dir, "backup")); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(datadir, "mydb", "rp", "1", "*"))
+	if len(matches) != 0 {
+		t.Fatalf("unexpected restored files: %v", matches)
+	}
+}
+
+// Ensure that a filtered restore skips the tombstones of TSM files without
+// matching data, whether the tombstone is archived before or after the TSM file.
+func TestCommand_Run_Filter_NoMatchTombstone(t *testing.T) {
+	const (
+		tsmName       = "mydb/rp/1/000000001-000000001.tsm"
+		tombstoneName = "mydb/rp/1/000000001-000000001.tombstone"
+	)
+	files := map[string]string{
+		tsmName: MustTSMFile(map[string][]tsm1.Value{
+			"mem#!~#value": {tsm1.NewValue(0, 1.0)},
+		}),
+		tombstoneName: "mem#!~#value\n",
+	}
+
+	for _, names := range [][]string{
+		{tsmName, tombstoneName},
+		{tombstoneName, tsmName},
+	} {
+		dir := MustTempDir()
+		defer os.RemoveAll(dir)
+
+		MustWriteShardBackupFiles(filepath.Join(dir, "backup"), names, files)
+
+		datadir := filepath.Join(dir, "data")
+		if err := restore.NewCommand().Run("-database", "mydb", "-datadir", datadir, "-measurement", "cpu", filepath.Join(dir, "backup")); err != nil {
+			t.Fatal(err)
+		}
+
+		matches, _ := filepath.Glob(filepath.Join(datadir, "mydb", "rp", "1", "*"))
+		if len(matches) != 0 {
+			t.Fatalf("unexpected restored files for %v: %v", names, matches)
+		}
+	}
+}
+
+// Ensure that a filtered restore skips a tombstone in an incremental backup for
+// a TSM file that was dropped from an earlier backup.
+func TestCommand_Run_Filter_NoMatchTombstone_Incremental(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	const (
+		tsmName       = "mydb/rp/1/000000001-000000001.tsm"
+		tombstoneName = "mydb/rp/1/000000001-000000001.tombstone"
+	)
+	MustWriteShardBackupIncrement(filepath.Join(dir, "backup"), 0, []string{tsmName}, map[string]string{
+		tsmName: MustTSMFile(map[string][]tsm1.Value{
+			"mem#!~#value": {tsm1.NewValue(0, 1.0)},
+		}),
+	})
+	MustWriteShardBackupIncrement(filepath.Join(dir, "backup"), 1, []string{tombstoneName}, map[string]string{
+		tombstoneName: "mem#!~#value\n",
+	})
+
+	datadir := filepath.Join(dir, "data")
+	if err := restore.NewCommand().Run("-database", "mydb", "-datadir", datadir, "-measurement", "cpu", filepath.Join(dir, "backup")); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(datadir, "mydb", "rp", "1", "*"))
+	if len(matches) != 0 {
+		t.Fatalf("unexpected restored files: %v", matches)
+	}
+}
+
+// Ensure that restore stops before unpacking more files than -max-files,
+// counting the files of every backup increment.
+func TestCommand_Run_MaxFiles(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	const (
+		first  = "mydb/rp/1/000000001-000000001.tsm"
+		second = "mydb/rp/1/000000002-000000001.tsm"
+	)
+	MustWriteShardBackupIncrement(filepath.Join(dir, "backup"), 0, []string{first}, map[string]string{first: "first tsm"})
+	MustWriteShardBackupIncrement(filepath.Join(dir, "backup"), 1, []string{second}, map[string]string{second: "second tsm"})
+
+	datadir := filepath.Join(dir, "data")
+	err := restore.NewCommand().Run("-database", "mydb", "-datadir", datadir, "-max-files", "1", filepath.Join(dir, "backup"))
+	if err != restore.ErrRestoreLimitExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(datadir, "mydb", "rp", "1", "*"))
+	if exp := []string{filepath.Join(datadir, filepath.FromSlash(first))}; !reflect.DeepEqual(matches, exp) {
+		t.Fatalf("unexpected restored files: %v", matches)
+	}
+}
+
+// Ensure that restore stops before unpacking a file of a compressed backup
+// that would exceed -max-bytes.
+func TestCommand_Run_MaxBytes(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	const (
+		first  = "mydb/rp/1/000000001-000000001.tsm"
+		second = "mydb/rp/1/000000002-000000001.tsm"
+	)
+	path := MustWriteShardBackupFiles(filepath.Join(dir, "backup"), []string{first, second}, map[string]string{
+		first:  "first tsm",
+		second: strings.Repeat("x", 1<<20),
+	})
+	MustGzipFile(path)
+
+	datadir := filepath.Join(dir, "data")
+	err := restore.NewCommand().Run("-database", "mydb", "-datadir", datadir, "-max-bytes", "1024", filepath.Join(dir, "backup"))
+	if err != restore.ErrRestoreLimitExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(datadir, "mydb", "rp", "1", "*"))
+	if exp := []string{filepath.Join(datadir, filepath.FromSlash(first))}; !reflect.DeepEqual(matches, exp) {
+		t.Fatalf("unexpected restored files: %v", matches)
+	}
+}
+
+// MustTempDir returns a new temporary directory. Panic on error.
+func MustTempDir() string {
+	dir, err := ioutil.TempDir("", "influxd-restore-")
+	if err != nil {
+		panic(err)
+	}
+	return dir
+}
+
+// MustWriteShardBackup writes a backup of shard 1 of mydb.rp containing files,
+// along with its manifest, and returns the path of the backup. Panic on error.
+func MustWriteShardBackup(dir string, files map[string]string) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return MustWriteShardBackupFiles(dir, names, files)
+}
+
+// MustWriteShardBackupFiles writes a backup like MustWriteShardBackup with the
+// files archived in the order of names. Panic on error.
+func MustWriteShardBackupFiles(dir string, names []string, files map[string]string) string {
+	return MustWriteShardBackupIncrement(dir, 0, names, files)
+}
+
+// MustWriteShardBackupIncrement writes the backup increment n of shard 1 of mydb.rp
+// like MustWriteShardBackupFiles. Panic on error.
+func MustWriteShardBackupIncrement(dir string, n int, names []string, files map[string]string) string {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		panic(err)
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("mydb.rp.00001.%02d", n))
+	f, err := os.Create(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	for _, name := range names {
+		data := files[name]
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0666, Size: int64(len(data))}); err != nil {
+			panic(err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			panic(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		panic(err)
+	}
+	if err := f.Close(); err != nil {
+		panic(err)
+	}
+
+	m, err := backup.CreateManifest(path)
+	if err != nil {
+		panic(err)
+	}
+	if err := backup.WriteManifest(path, m); err != nil {
+		panic(err)
+	}
+	return path
+}
+
+// MustGzipFile compresses the backup at path as a server does for
+// "influxd backup -compress". The manifest describes the archived files so it
+// does not change. Panic on error.
+func MustGzipFile(path string) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(b); err != nil {
+		panic(err)
+	} else if err := gw.Close(); err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		panic(err)
+	}
+}
+
+// MustTSMFile returns the contents of a TSM file with a block for each key. Panic on error.
+func MustTSMFile(values map[string][]tsm1.Value) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	w, err := tsm1.NewTSMWriter(&buf)
+	if err != nil {
+		panic(err)
+	}
+	for _, k := range keys {
+		if err := w.Write(k, values[k]); err != nil {
+			panic(err)
+		}
+	}
+	if err := w.WriteIndex(); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return buf.String()
+}
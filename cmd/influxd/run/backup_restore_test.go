@@ -10,10 +10,23 @@ import (
 
 	"github.com/influxdata/influxdb/cmd/influxd/backup"
 	"github.com/influxdata/influxdb/cmd/influxd/restore"
+	"github.com/influxdata/influxdb/cmd/influxd/verify"
 )
 
 func TestServer_BackupAndRestore(t *testing.T) {
 	t.Skip("currently fails intermittently.  See issue https://github.com/influxdata/influxdb/issues/6590")
+	testServerBackupAndRestore(t)
+}
+
+// Ensure that shard backups compressed by the server can be restored.
+func TestServer_BackupAndRestore_Compressed(t *testing.T) {
+	t.Skip("currently fails intermittently.  See issue https://github.com/influxdata/influxdb/issues/6590")
+	testServerBackupAndRestore(t, "-compress")
+}
+
+// testServerBackupAndRestore backs up a database using the given extra backup
+// arguments, restores it and verifies the data can be queried.
+func testServerBackupAndRestore(t *testing.T, backupArgs ...string) {
 	config := NewConfig()
 	config.Data.Engine = "tsm1"
 	config.Data.Dir, _ = ioutil.TempDir("", "data_backup")
@@ -63,11 +76,25 @@ func TestServer_BackupAndRestore(t *testing.T) {
 			t.Fatal(err)
 		}
 		hostAddress := net.JoinHostPort("localhost", port)
-		if err := cmd.Run("-host", hostAddress, "-database", "mydb", backupDir); err != nil {
+		args := append([]string{"-host", hostAddress, "-database", "mydb"}, backupArgs...)
+		if err := cmd.Run(append(args, backupDir)...); err != nil {
 			t.Fatalf("error backing up: %s, hostAddress: %s", err.Error(), hostAddress)
 		}
 	}()
 
+	// Make sure the shard backup has a manifest
+	manifestPath := filepath.Join(backupDir, "mydb.forever.00001.00"+backup.ManifestSuffix)
+	if _, err := os.Stat(manifestPath); err != nil {
+		t.Fatalf("manifest should exist: %s", err)
+	}
+
+	// Make sure the backup passes verification
+	verifyCmd := verify.NewCommand()
+	verifyCmd.Stdout = ioutil.Discard
+	if err := verifyCmd.Run(backupDir); err != nil {
+		t.Fatalf("error verifying backup: %s", err.Error())
+	}
+
 	if _, err := os.Stat(config.Meta.Dir); err == nil || !os.IsNotExist(err) {
 		t.Fatalf("meta dir should be deleted")
 	}
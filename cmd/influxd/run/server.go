@@ -216,8 +216,8 @@ func (s *Server) Statistics(tags map[string]string) []models.Statistic {
 	return statistics
 }
 
-func (s *Server) appendSnapshotterService() {
-	srv := snapshotter.NewService()
+func (s *Server) appendSnapshotterService(c snapshotter.Config) {
+	srv := snapshotter.NewService(c)
 	srv.TSDBStore = s.TSDBStore
 	srv.MetaClient = s.MetaClient
 	s.Services = append(s.Services, srv)
@@ -265,6 +265,8 @@ func (s *Server) appendHTTPDService(c httpd.Config) {
 	srv.Handler.QueryExecutor = s.QueryExecutor
 	srv.Handler.Monitor = s.Monitor
 	srv.Handler.PointsWriter = s.PointsWriter
+	srv.Handler.TSDBStore = s.TSDBStore
+	srv.Handler.Snapshotter = s.SnapshotterService
 	srv.Handler.Version = s.buildInfo.Version
 
 	// If a ContinuousQuerier service has been started, attach it.
@@ -373,7 +375,7 @@ func (s *Server) Open() error {
 	// Append services.
 	s.appendMonitorService()
 	s.appendPrecreatorService(s.config.Precreator)
-	s.appendSnapshotterService()
+	s.appendSnapshotterService(s.config.Snapshotter)
 	s.appendAdminService(s.config.Admin)
 	s.appendContinuousQueryService(s.config.ContinuousQuery)
 	s.appendHTTPDService(s.config.HTTPD)
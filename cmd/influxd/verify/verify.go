@@ -0,0 +1,257 @@
+package verify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/influxdata/influxdb/cmd/influxd/backup"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/services/snapshotter"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// Command represents the program execution for "influxd verify-backup".
+type Command struct {
+	// Standard input/output, overridden for testing.
+	Stderr io.Writer
+	Stdout io.Writer
+
+	path     string
+	database string
+}
+
+// NewCommand returns a new instance of Command with default settings.
+func NewCommand() *Command {
+	return &Command{
+		Stderr: os.Stderr,
+		Stdout: os.Stdout,
+	}
+}
+
+// Run executes the program.
+func (cmd *Command) Run(args ...string) error {
+	if err := cmd.parseFlags(args); err != nil {
+		return err
+	}
+
+	paths, err := cmd.backupFiles()
+	if err != nil {
+		return err
+	} else if len(paths) == 0 {
+		return fmt.Errorf("no backup files in %s", cmd.path)
+	}
+
+	var failed int
+	for _, path := range paths {
+		var err error
+		if strings.HasPrefix(filepath.Base(path), backup.Metafile+".") {
+			err = verifyMeta(path)
+		} else {
+			err = verifyShard(path)
+		}
+
+		if err != nil {
+			failed++
+			fmt.Fprintf(cmd.Stdout, "%s: FAILED: %s\n", path, err)
+			continue
+		}
+		fmt.Fprintf(cmd.Stdout, "%s: ok\n", path)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d backup files failed verification", failed, len(paths))
+	}
+	return nil
+}
+
+// parseFlags parses and validates the command line arguments.
+func (cmd *Command) parseFlags(args []string) error {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.StringVar(&cmd.database, "database", "", "")
+	fs.SetOutput(cmd.Stderr)
+	fs.Usage = cmd.printUsage
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// Ensure that only one arg is specified.
+	if fs.NArg() == 0 {
+		return errors.New("backup path required")
+	} else if fs.NArg() != 1 {
+		return errors.New("only one backup path allowed")
+	}
+	cmd.path = fs.Arg(0)
+
+	return nil
+}
+
+// backupFiles returns the metastore and shard backups in the backup directory.
+// Only the shard backups of the database are returned if one was specified.
+func (cmd *Command) backupFiles() ([]string, error) {
+	pat := filepath.Join(cmd.path, "*")
+	if cmd.database != "" {
+		pat = filepath.Join(cmd.path, cmd.database+".*")
+	}
+
+	matches, err := filepath.Glob(pat)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, path := range matches {
+		// Skip manifests, which are verified along with their backups, and
+		// any backups that were not completely downloaded.
+		if strings.HasSuffix(path, backup.ManifestSuffix) || strings.HasSuffix(path, backup.Suffix) {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// verifyMeta checks that the metastore backup at path can be decoded.
+func verifyMeta(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	metaBytes, _, err := snapshotter.UnmarshalMetastoreBackup(b)
+	if err != nil {
+		return err
+	}
+
+	var data meta.Data
+	if err := data.UnmarshalBinary(metaBytes); err != nil {
+		return fmt.Errorf("unmarshal: %s", err)
+	}
+	return nil
+}
+
+// verifyShard checks that the shard backup at path is a readable archive whose
+// files match its manifest and whose TSM files have valid block checksums.
+func verifyShard(path string) error {
+	manifest, err := backup.ReadManifest(path)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr, err := backup.NewArchiveReader(f)
+	if err != nil {
+		return err
+	}
+
+	var n int
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		n++
+
+		var mf *backup.ManifestFile
+		if manifest != nil {
+			if mf = manifest.File(hdr.Name); mf == nil {
+				return fmt.Errorf("%s: not in manifest", hdr.Name)
+			}
+		}
+
+		if err := verifyFile(tr, hdr.Name, mf); err != nil {
+			return fmt.Errorf("%s: %s", hdr.Name, err)
+		}
+	}
+
+	if manifest != nil && n != len(manifest.Files) {
+		return fmt.Errorf("archive has %d files, manifest lists %d", n, len(manifest.Files))
+	}
+	return nil
+}
+
+// verifyFile checks the archive file being read from r against its manifest
+// entry, if there is one, and checks the blocks of TSM files.
+func verifyFile(r io.Reader, name string, mf *backup.ManifestFile) error {
+	// The TSM reader requires a file so copy the data to a temporary file.
+	tmp, err := ioutil.TempFile("", "influxd-verify-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		return err
+	}
+
+	if mf != nil {
+		if size != mf.Size {
+			return fmt.Errorf("size mismatch: got %d, expected %d", size, mf.Size)
+		} else if hex.EncodeToString(h.Sum(nil)) != mf.SHA256 {
+			return errors.New("checksum mismatch")
+		}
+	}
+
+	if filepath.Ext(name) != "."+tsm1.TSMFileExtension {
+		return nil
+	}
+	return verifyTSM(tmp)
+}
+
+// verifyTSM checks the checksum of every block in the TSM file.
+func verifyTSM(f *os.File) error {
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+
+	tr, err := tsm1.NewTSMReader(f)
+	if err != nil {
+		return err
+	}
+	defer tr.Close()
+
+	itr := tr.BlockIterator()
+	for i := 0; itr.Next(); i++ {
+		key, _, _, checksum, buf, err := itr.Read()
+		if err != nil {
+			return fmt.Errorf("block %d: %s", i, err)
+		} else if expected := crc32.ChecksumIEEE(buf); checksum != expected {
+			return fmt.Errorf("checksum mismatch for key %s, block %d", key, i)
+		}
+	}
+	return nil
+}
+
+// printUsage prints the usage message to STDERR.
+func (cmd *Command) printUsage() {
+	fmt.Fprintf(cmd.Stdout, `Verifies that the backups in a backup directory can be restored.
+
+Usage: influxd verify-backup [flags] PATH
+
+    -database <name>
+            Optional. Only verify the shard backups of the database.
+
+Every metastore backup is decoded. Every shard backup is read in full and
+its files are checked against the manifest written by "influxd backup".
+The checksum of every block in each TSM file is verified.
+
+`)
+}
@@ -0,0 +1,165 @@
+package verify_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/influxdata/influxdb/cmd/influxd/backup"
+	"github.com/influxdata/influxdb/cmd/influxd/verify"
+	"github.com/influxdata/influxdb/services/snapshotter"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// Ensure that a valid shard backup passes verification.
+func TestCommand_Run(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	MustWriteShardBackup(dir, MustTSMFile())
+
+	cmd := verify.NewCommand()
+	cmd.Stdout = ioutil.Discard
+	if err := cmd.Run(dir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a shard backup that does not match its manifest fails verification.
+func TestCommand_Run_ChecksumMismatch(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	path := MustWriteShardBackup(dir, MustTSMFile())
+
+	// Corrupt the first byte of the file data, which follows its header.
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[512] ^= 0xff
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := verify.NewCommand()
+	cmd.Stdout = ioutil.Discard
+	if err := cmd.Run(dir); err == nil {
+		t.Fatal("expected verification error")
+	}
+}
+
+// Ensure that a TSM file with a corrupt block fails verification.
+func TestCommand_Run_CorruptBlock(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	// Corrupt the block data, which follows the 5 byte file header and the
+	// 4 byte block checksum.
+	data := MustTSMFile()
+	data[9] ^= 0xff
+	path := MustWriteShardBackup(dir, data)
+
+	// Remove the manifest so that only the block checksums are checked.
+	if err := os.Remove(path + backup.ManifestSuffix); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	cmd := verify.NewCommand()
+	cmd.Stdout = &buf
+	if err := cmd.Run(dir); err == nil {
+		t.Fatal("expected verification error")
+	} else if !bytes.Contains(buf.Bytes(), []byte("checksum mismatch for key cpu")) {
+		t.Fatalf("unexpected output: %s", buf.String())
+	}
+}
+
+// Ensure that a metastore backup written in a newer format fails verification.
+func TestCommand_Run_Meta_NewerVersion(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	var b [32]byte
+	binary.BigEndian.PutUint64(b[:8], snapshotter.BackupMagicHeaderVersioned)
+	binary.BigEndian.PutUint64(b[8:16], snapshotter.MetastoreBackupVersion+1)
+	if err := ioutil.WriteFile(filepath.Join(dir, backup.Metafile+".00"), b[:], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	cmd := verify.NewCommand()
+	cmd.Stdout = &buf
+	if err := cmd.Run(dir); err == nil {
+		t.Fatal("expected verification error")
+	} else if !bytes.Contains(buf.Bytes(), []byte("newer than the supported version")) {
+		t.Fatalf("unexpected output: %s", buf.String())
+	}
+}
+
+// MustTempDir returns a new temporary directory. Panic on error.
+func MustTempDir() string {
+	dir, err := ioutil.TempDir("", "influxd-verify-")
+	if err != nil {
+		panic(err)
+	}
+	return dir
+}
+
+// MustTSMFile returns the contents of a TSM file with a single block. Panic on error.
+func MustTSMFile() []byte {
+	var buf bytes.Buffer
+	w, err := tsm1.NewTSMWriter(&buf)
+	if err != nil {
+		panic(err)
+	}
+	if err := w.Write("cpu", []tsm1.Value{tsm1.NewValue(0, 1.0), tsm1.NewValue(1, 2.0)}); err != nil {
+		panic(err)
+	}
+	if err := w.WriteIndex(); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// MustWriteShardBackup writes a backup of shard 1 of mydb.rp containing a
+// TSM file, along with its manifest, and returns the path of the backup.
+// Panic on error.
+func MustWriteShardBackup(dir string, data []byte) string {
+	path := filepath.Join(dir, "mydb.rp.00001.00")
+	f, err := os.Create(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	if err := tw.WriteHeader(&tar.Header{Name: "mydb/rp/1/000000001-000000001.tsm", Mode: 0666, Size: int64(len(data))}); err != nil {
+		panic(err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		panic(err)
+	}
+	if err := tw.Close(); err != nil {
+		panic(err)
+	}
+	if err := f.Close(); err != nil {
+		panic(err)
+	}
+
+	m, err := backup.CreateManifest(path)
+	if err != nil {
+		panic(err)
+	}
+	if err := backup.WriteManifest(path, m); err != nil {
+		panic(err)
+	}
+	return path
+}
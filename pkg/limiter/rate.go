@@ -0,0 +1,103 @@
+package limiter
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Writer is an io.Writer that limits the rate at which bytes are written to the
+// underlying writer. Large writes are split so that no single write to the
+// underlying writer is larger than one second's worth of bytes.
+type Writer struct {
+	w io.Writer
+	r *Rate
+}
+
+// NewWriter returns a writer that writes to w at no more than limit bytes per second.
+func NewWriter(w io.Writer, limit int) *Writer {
+	return NewRateWriter(w, NewRate(limit))
+}
+
+// NewRateWriter returns a writer that writes to w within the limit of r. The
+// limit applies to all readers and writers sharing r.
+func NewRateWriter(w io.Writer, r *Rate) *Writer {
+	return &Writer{w: w, r: r}
+}
+
+// Write writes p to the underlying writer, blocking as needed to stay within the limit.
+func (w *Writer) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > w.r.limit {
+			chunk = chunk[:w.r.limit]
+		}
+
+		m, err := w.w.Write(chunk)
+		n += m
+		w.r.Wait(m)
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// Reader is an io.Reader that limits the rate at which bytes are read from the
+// underlying reader.
+type Reader struct {
+	rd io.Reader
+	r  *Rate
+}
+
+// NewReader returns a reader that reads from r at no more than limit bytes per second.
+func NewReader(r io.Reader, limit int) *Reader {
+	return &Reader{rd: r, r: NewRate(limit)}
+}
+
+// Read reads from the underlying reader, blocking as needed to stay within the limit.
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(p) > r.r.limit {
+		p = p[:r.r.limit]
+	}
+
+	n, err := r.rd.Read(p)
+	r.r.Wait(n)
+	return n, err
+}
+
+// Rate tracks the bytes transferred against a limit in bytes per second. It is
+// safe for concurrent use, so a single Rate can limit the combined rate of
+// several readers and writers.
+type Rate struct {
+	mu    sync.Mutex
+	limit int
+
+	// next is the earliest time at which more bytes may be transferred.
+	next time.Time
+}
+
+// NewRate returns a Rate that allows limit bytes per second.
+func NewRate(limit int) *Rate {
+	return &Rate{limit: limit}
+}
+
+// Wait records that n bytes were transferred and sleeps until transferring
+// them no longer exceeds the limit. Unused time is not saved up, so an idle
+// period is never followed by a burst above the limit.
+func (r *Rate) Wait(n int) {
+	r.mu.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	r.next = r.next.Add(time.Duration(float64(n) / float64(r.limit) * float64(time.Second)))
+	d := r.next.Sub(now)
+	r.mu.Unlock()
+
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
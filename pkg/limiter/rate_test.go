@@ -0,0 +1,76 @@
+package limiter_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/pkg/limiter"
+)
+
+// Ensure the writer does not exceed its rate limit.
+func TestWriter_Write(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 3000)
+
+	var buf bytes.Buffer
+	w := limiter.NewWriter(&buf, 2000)
+
+	start := time.Now()
+	if n, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	} else if n != len(data) {
+		t.Fatalf("unexpected bytes written: exp %d, got %d", len(data), n)
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("write completed too quickly: %s", elapsed)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("unexpected data written")
+	}
+}
+
+// Ensure writers sharing a rate do not exceed its limit together.
+func TestRateWriter_Write_Shared(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 2000)
+	r := limiter.NewRate(2000)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := limiter.NewRateWriter(ioutil.Discard, r).Write(data); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Each writer alone would take one second.
+	if elapsed := time.Since(start); elapsed < 1500*time.Millisecond {
+		t.Fatalf("writes completed too quickly: %s", elapsed)
+	}
+}
+
+// Ensure the reader does not exceed its rate limit.
+func TestReader_Read(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 3000)
+	r := limiter.NewReader(bytes.NewReader(data), 2000)
+
+	start := time.Now()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("read completed too quickly: %s", elapsed)
+	}
+	if !bytes.Equal(b, data) {
+		t.Fatal("unexpected data read")
+	}
+}
@@ -27,6 +27,7 @@ type Config struct {
 	Realm              string `toml:"realm"`
 	UnixSocketEnabled  bool   `toml:"unix-socket-enabled"`
 	BindSocket         string `toml:"bind-socket"`
+	BackupEnabled      bool   `toml:"backup-enabled"`
 }
 
 // NewConfig returns a new Config with default settings.
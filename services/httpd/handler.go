@@ -1,6 +1,8 @@
 package httpd
 
 import (
+	"archive/tar"
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
@@ -8,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/pprof"
 	"os"
@@ -88,6 +91,16 @@ type Handler struct {
 		WritePoints(database, retentionPolicy string, consistencyLevel models.ConsistencyLevel, points []models.Point) error
 	}
 
+	TSDBStore interface {
+		ShardIDs() []uint64
+	}
+
+	// Snapshotter writes the shard backups served by the backup endpoint,
+	// within the rate limit of the snapshotter service.
+	Snapshotter interface {
+		BackupShard(id uint64, since time.Time, w io.Writer) error
+	}
+
 	ContinuousQuerier continuous_querier.ContinuousQuerier
 
 	Config    *Config
@@ -143,6 +156,10 @@ func NewHandler(c Config) *Handler {
 			"status-head",
 			"HEAD", "/status", false, true, h.serveStatus,
 		},
+		Route{ // Download a backup of shards
+			"backup",
+			"GET", "/backup", true, true, h.serveBackup,
+		},
 		// TODO: (corylanou) remove this and associated code
 		Route{ // Tell data node to run CQs that should be run
 			"process-continuous-queries",
@@ -703,6 +720,168 @@ func (h *Handler) serveStatus(w http.ResponseWriter, r *http.Request) {
 	h.writeHeader(w, http.StatusNoContent)
 }
 
+// serveBackup streams a tar archive of the files of the local shards in a
+// database, retention policy or shard. Only files modified after the since
+// parameter are included. The archive can be extracted into a data directory.
+// Backups contain whole files, so the start and end parameters are rejected
+// rather than filtering the data by time.
+func (h *Handler) serveBackup(w http.ResponseWriter, r *http.Request, user *meta.UserInfo) {
+	if !h.Config.BackupEnabled {
+		h.httpError(w, "backups over HTTP are disabled", http.StatusForbidden)
+		return
+	}
+
+	if h.Config.AuthEnabled && (user == nil || !user.Admin) {
+		h.httpError(w, "admin privileges required to back up", http.StatusForbidden)
+		return
+	}
+
+	q := r.URL.Query()
+	if q.Get("start") != "" || q.Get("end") != "" {
+		h.httpError(w, "start and end are not supported, use since", http.StatusBadRequest)
+		return
+	}
+
+	database := q.Get("db")
+	if database == "" {
+		h.httpError(w, "database is required", http.StatusBadRequest)
+		return
+	}
+
+	di := h.MetaClient.Database(database)
+	if di == nil {
+		h.httpError(w, fmt.Sprintf("database not found: %q", database), http.StatusNotFound)
+		return
+	}
+
+	var since time.Time
+	if s := q.Get("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			h.httpError(w, fmt.Sprintf("invalid since: %s", err), http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+
+	var shardID uint64
+	if s := q.Get("shard"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			h.httpError(w, fmt.Sprintf("invalid shard: %s", err), http.StatusBadRequest)
+			return
+		}
+		shardID = id
+	}
+
+	// Find the local shards to back up.
+	local := make(map[uint64]struct{})
+	for _, id := range h.TSDBStore.ShardIDs() {
+		local[id] = struct{}{}
+	}
+
+	var ids []uint64
+	rp := q.Get("rp")
+	for _, rpi := range di.RetentionPolicies {
+		if rp != "" && rpi.Name != rp {
+			continue
+		}
+		for _, sg := range rpi.ShardGroups {
+			for _, sh := range sg.Shards {
+				if _, ok := local[sh.ID]; !ok {
+					continue
+				} else if shardID != 0 && sh.ID != shardID {
+					continue
+				}
+				ids = append(ids, sh.ID)
+			}
+		}
+	}
+
+	if len(ids) == 0 {
+		h.httpError(w, "no shards to back up", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", database+".tar"))
+
+	// Combine the backup of each shard into a single archive.
+	bw := &backupWriter{w: w}
+	tw := tar.NewWriter(bw)
+	for _, id := range ids {
+		if err := h.writeShardBackup(tw, id, since); err != nil {
+			h.backupError(w, bw.started, fmt.Sprintf("error backing up shard %d: %s", id, err))
+			return
+		}
+	}
+	if err := tw.Close(); err != nil {
+		h.backupError(w, bw.started, fmt.Sprintf("error backing up database %s: %s", database, err))
+	}
+}
+
+// backupError reports an error in a backup. If the response has not started an
+// error response is sent. Otherwise the connection is closed without ending the
+// response so the client cannot mistake the partial archive for a complete one.
+func (h *Handler) backupError(w http.ResponseWriter, started bool, msg string) {
+	h.Logger.Print(msg)
+	if !started {
+		w.Header().Del("Content-Disposition")
+		w.Header().Set("Content-Type", "application/json")
+		h.httpError(w, msg, http.StatusInternalServerError)
+		return
+	}
+
+	if hj, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hj.Hijack(); err == nil {
+			conn.Close()
+			return
+		}
+	}
+	h.Logger.Print("unable to abort backup response, the client may receive a truncated backup")
+}
+
+// backupWriter writes a backup archive to a response. The status is written
+// explicitly before the first byte of the archive so that a gzip filter marks
+// the response as compressed. Until then an error status can still be sent.
+type backupWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (w *backupWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.w.WriteHeader(http.StatusOK)
+	}
+	return w.w.Write(p)
+}
+
+// writeShardBackup copies the files in the backup of a shard into tw.
+func (h *Handler) writeShardBackup(tw *tar.Writer, id uint64, since time.Time) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(h.Snapshotter.BackupShard(id, since, pw))
+	}()
+	defer pr.Close()
+
+	tr := tar.NewReader(pr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		} else if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
 // convertToEpoch converts result timestamps from time.Time to the specified epoch.
 func convertToEpoch(r *influxql.Result, epoch string) {
 	divisor := int64(1)
@@ -1009,6 +1188,18 @@ func (w gzipResponseWriter) CloseNotify() <-chan bool {
 	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
 }
 
+func (w gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijack(w.ResponseWriter)
+}
+
+// hijack hijacks the connection of w if it supports it.
+func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("response writer does not support hijacking")
+}
+
 // determines if the client can accept compressed responses, and encodes accordingly
 func gzipFilter(inner http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
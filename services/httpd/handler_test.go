@@ -1,6 +1,7 @@
 package httpd_test
 
 import (
+	"archive/tar"
 	"bytes"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"regexp"
 	"strings"
 	"testing"
@@ -593,6 +595,176 @@ func TestHandler_HandleBadRequestBody(t *testing.T) {
 	}
 }
 
+// Ensure the handler streams a backup of the local shards of a database.
+func TestHandler_Backup(t *testing.T) {
+	h := NewHandler(false)
+	h.Config.BackupEnabled = true
+	h.MetaClient.DatabaseFn = func(name string) *meta.DatabaseInfo {
+		if name != "db0" {
+			return nil
+		}
+		return &meta.DatabaseInfo{
+			Name: "db0",
+			RetentionPolicies: []meta.RetentionPolicyInfo{{
+				Name: "rp0",
+				ShardGroups: []meta.ShardGroupInfo{{
+					Shards: []meta.ShardInfo{{ID: 1}, {ID: 2}, {ID: 3}},
+				}},
+			}},
+		}
+	}
+	// Shard 3 is not stored on this node.
+	h.TSDBStore.ShardIDsFn = func() []uint64 { return []uint64{1, 2, 4} }
+	h.Snapshotter.BackupShardFn = func(id uint64, since time.Time, w io.Writer) error {
+		// This runs on the handler's pipe goroutine, so fail without t.Fatal.
+		if !since.Equal(time.Unix(60, 0)) {
+			t.Errorf("unexpected since: %s", since)
+			return fmt.Errorf("unexpected since: %s", since)
+		}
+		name := fmt.Sprintf("db0/rp0/%d/000000001-000000001.tsm", id)
+		tw := tar.NewWriter(w)
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0666, Size: 4}); err != nil {
+			return err
+		} else if _, err := tw.Write([]byte("data")); err != nil {
+			return err
+		}
+		return tw.Close()
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewRequest("GET", "/backup?db=db0&since=1970-01-01T00:01:00Z", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", w.Code, w.Body.String())
+	}
+
+	var names []string
+	tr := tar.NewReader(w.Body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if exp := []string{
+		"db0/rp0/1/000000001-000000001.tsm",
+		"db0/rp0/2/000000001-000000001.tsm",
+	}; !reflect.DeepEqual(names, exp) {
+		t.Fatalf("unexpected files: %v", names)
+	}
+
+	// The backup can be requested compressed.
+	w = httptest.NewRecorder()
+	r := MustNewRequest("GET", "/backup?db=db0&since=1970-01-01T00:01:00Z", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	} else if enc := w.HeaderMap.Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("unexpected content encoding: %q", enc)
+	}
+}
+
+// Ensure the handler reports a failed backup instead of a truncated archive
+// that looks complete.
+func TestHandler_Backup_Failure(t *testing.T) {
+	h := NewHandler(false)
+	h.Config.BackupEnabled = true
+	h.MetaClient.DatabaseFn = func(name string) *meta.DatabaseInfo {
+		return &meta.DatabaseInfo{
+			Name: "db0",
+			RetentionPolicies: []meta.RetentionPolicyInfo{{
+				Name:        "rp0",
+				ShardGroups: []meta.ShardGroupInfo{{Shards: []meta.ShardInfo{{ID: 1}, {ID: 2}}}},
+			}},
+		}
+	}
+	h.TSDBStore.ShardIDsFn = func() []uint64 { return []uint64{1, 2} }
+
+	// A failure before the response starts is reported with an error status.
+	h.Snapshotter.BackupShardFn = func(id uint64, since time.Time, w io.Writer) error {
+		return errors.New("marker")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, MustNewRequest("GET", "/backup?db=db0", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("unexpected status: %d", w.Code)
+	} else if !strings.Contains(w.Body.String(), "marker") {
+		t.Fatalf("unexpected body: %s", w.Body.String())
+	}
+
+	// A failure after the response starts closes the connection.
+	h.Snapshotter.BackupShardFn = func(id uint64, since time.Time, w io.Writer) error {
+		if id == 2 {
+			return errors.New("marker")
+		}
+		// The data fills a whole tar block, so the archive ends on an entry
+		// boundary when shard 2 fails.
+		data := bytes.Repeat([]byte("x"), 512)
+		tw := tar.NewWriter(w)
+		if err := tw.WriteHeader(&tar.Header{Name: "db0/rp0/1/000000001-000000001.tsm", Mode: 0666, Size: int64(len(data))}); err != nil {
+			return err
+		} else if _, err := tw.Write(data); err != nil {
+			return err
+		}
+		return tw.Close()
+	}
+
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/backup?db=db0")
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	tr := tar.NewReader(resp.Body)
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			t.Fatal("expected truncated backup")
+		} else if err != nil {
+			return
+		}
+	}
+}
+
+// Ensure the handler rejects backup requests that are not allowed or invalid.
+func TestHandler_Backup_Errors(t *testing.T) {
+	h := NewHandler(true)
+	h.MetaClient.DatabaseFn = func(name string) *meta.DatabaseInfo { return nil }
+	h.MetaClient.UsersFn = func() []meta.UserInfo {
+		return []meta.UserInfo{{Name: "admin", Admin: true}}
+	}
+	h.MetaClient.UserFn = func(username string) (*meta.UserInfo, error) {
+		return &meta.UserInfo{Name: username, Admin: username == "admin"}, nil
+	}
+	h.MetaClient.AuthenticateFn = func(username, password string) (*meta.UserInfo, error) {
+		return &meta.UserInfo{Name: username, Admin: username == "admin"}, nil
+	}
+
+	for _, tt := range []struct {
+		enabled bool
+		url     string
+		code    int
+	}{
+		{enabled: false, url: "/backup?db=db0&u=admin&p=x", code: http.StatusForbidden},
+		{enabled: true, url: "/backup?db=db0&u=user&p=x", code: http.StatusForbidden},
+		{enabled: true, url: "/backup?u=admin&p=x", code: http.StatusBadRequest},
+		{enabled: true, url: "/backup?db=db0&start=2000-01-01T00:00:00Z&u=admin&p=x", code: http.StatusBadRequest},
+		{enabled: true, url: "/backup?db=db0&u=admin&p=x", code: http.StatusNotFound},
+	} {
+		h.Config.BackupEnabled = tt.enabled
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, MustNewRequest("GET", tt.url, nil))
+		if w.Code != tt.code {
+			t.Errorf("%s: unexpected status: exp %d, got %d", tt.url, tt.code, w.Code)
+		}
+	}
+}
+
 type invalidJSON struct{}
 
 func (*invalidJSON) MarshalJSON() ([]byte, error) { return nil, errors.New("marker") }
@@ -603,6 +775,8 @@ type Handler struct {
 	MetaClient        HandlerMetaStore
 	StatementExecutor HandlerStatementExecutor
 	QueryAuthorizer   HandlerQueryAuthorizer
+	TSDBStore         HandlerTSDBStore
+	Snapshotter       HandlerSnapshotter
 }
 
 // NewHandler returns a new instance of Handler.
@@ -618,6 +792,8 @@ func NewHandler(requireAuthentication bool) *Handler {
 	h.Handler.QueryExecutor = influxql.NewQueryExecutor()
 	h.Handler.QueryExecutor.StatementExecutor = &h.StatementExecutor
 	h.Handler.QueryAuthorizer = &h.QueryAuthorizer
+	h.Handler.TSDBStore = &h.TSDBStore
+	h.Handler.Snapshotter = &h.Snapshotter
 	h.Handler.Version = "0.0.0"
 	return h
 }
@@ -673,6 +849,24 @@ func (a *HandlerQueryAuthorizer) AuthorizeQuery(u *meta.UserInfo, query *influxq
 	return a.AuthorizeQueryFn(u, query, database)
 }
 
+// HandlerTSDBStore is a mock implementation of Handler.TSDBStore.
+type HandlerTSDBStore struct {
+	ShardIDsFn func() []uint64
+}
+
+func (s *HandlerTSDBStore) ShardIDs() []uint64 {
+	return s.ShardIDsFn()
+}
+
+// HandlerSnapshotter is a mock implementation of Handler.Snapshotter.
+type HandlerSnapshotter struct {
+	BackupShardFn func(id uint64, since time.Time, w io.Writer) error
+}
+
+func (s *HandlerSnapshotter) BackupShard(id uint64, since time.Time, w io.Writer) error {
+	return s.BackupShardFn(id, since, w)
+}
+
 // MustNewRequest returns a new HTTP request. Panic on error.
 func MustNewRequest(method, urlStr string, body io.Reader) *http.Request {
 	r, err := http.NewRequest(method, urlStr, body)
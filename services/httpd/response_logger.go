@@ -1,6 +1,7 @@
 package httpd
 
 import (
+	"bufio"
 	"fmt"
 	"net"
 	"net/http"
@@ -26,6 +27,10 @@ func (l *responseLogger) CloseNotify() <-chan bool {
 	return make(<-chan bool)
 }
 
+func (l *responseLogger) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijack(l.w)
+}
+
 func (l *responseLogger) Header() http.Header {
 	return l.w.Header()
 }
@@ -1,9 +1,11 @@
 package httpd
 
 import (
+	"bufio"
 	"encoding/csv"
 	"encoding/json"
 	"io"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
@@ -62,6 +64,12 @@ func (w *responseWriter) Flush() {
 	}
 }
 
+// Hijack hijacks the connection of the underlying http.ResponseWriter if it
+// supports it.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijack(w.ResponseWriter)
+}
+
 // CloseNotify calls CloseNotify on the underlying http.ResponseWriter if it
 // exists. Otherwise, it returns a nil channel that will never notify.
 func (w *responseWriter) CloseNotify() <-chan bool {
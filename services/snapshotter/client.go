@@ -33,18 +33,10 @@ func (c *Client) MetastoreBackup() (*meta.Data, error) {
 		return nil, err
 	}
 
-	// Check the magic.
-	magic := binary.BigEndian.Uint64(b[:8])
-	if magic != BackupMagicHeader {
-		return nil, errors.New("invalid metadata received")
+	metaBytes, _, err := UnmarshalMetastoreBackup(b)
+	if err != nil {
+		return nil, err
 	}
-	i := 8
-
-	// Size of the meta store bytes.
-	length := int(binary.BigEndian.Uint64(b[i : i+8]))
-	i += 8
-	metaBytes := b[i : i+length]
-	i += int(length)
 
 	// Unpack meta data.
 	var data meta.Data
@@ -55,6 +47,55 @@ func (c *Client) MetastoreBackup() (*meta.Data, error) {
 	return &data, nil
 }
 
+// UnmarshalMetastoreBackup returns the meta data and node.json bytes of the
+// metastore backup b. Backups written before the format was versioned are
+// accepted, backups with a newer format version than this build are not.
+func UnmarshalMetastoreBackup(b []byte) (metaBytes, nodeBytes []byte, err error) {
+	if len(b) < 8 {
+		return nil, nil, errors.New("invalid metadata file")
+	}
+
+	// Check the magic and the format version.
+	i := 8
+	switch binary.BigEndian.Uint64(b[:8]) {
+	case BackupMagicHeader:
+	case BackupMagicHeaderVersioned:
+		if len(b) < i+8 {
+			return nil, nil, errors.New("metastore data truncated")
+		}
+		version := binary.BigEndian.Uint64(b[i : i+8])
+		if version > MetastoreBackupVersion {
+			return nil, nil, fmt.Errorf("metastore backup format version %d is newer than the supported version %d, restore with a newer build of influxd", version, MetastoreBackupVersion)
+		}
+		i += 8
+	default:
+		return nil, nil, errors.New("invalid metadata file")
+	}
+
+	// Size of the meta store bytes.
+	if len(b) < i+8 {
+		return nil, nil, errors.New("metastore data truncated")
+	}
+	length := binary.BigEndian.Uint64(b[i : i+8])
+	i += 8
+	if length > uint64(len(b)-i) {
+		return nil, nil, errors.New("metastore data truncated")
+	}
+	metaBytes = b[i : i+int(length)]
+	i += int(length)
+
+	// Size of the node.json bytes.
+	if len(b) < i+8 {
+		return nil, nil, errors.New("node data truncated")
+	}
+	length = binary.BigEndian.Uint64(b[i : i+8])
+	i += 8
+	if length > uint64(len(b)-i) {
+		return nil, nil, errors.New("node data truncated")
+	}
+	return metaBytes, b[i : i+int(length)], nil
+}
+
 // doRequest sends a request to the snapshotter service and returns the result.
 func (c *Client) doRequest(req *Request) ([]byte, error) {
 	// Connect to snapshotter service.
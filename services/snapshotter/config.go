@@ -0,0 +1,19 @@
+package snapshotter
+
+const (
+	// DefaultRateLimit is the default maximum rate, in bytes per second, at
+	// which shard backups are sent. Zero means unlimited.
+	DefaultRateLimit = 0
+)
+
+// Config represents the configuration for the snapshotter service.
+type Config struct {
+	RateLimit int `toml:"rate-limit"`
+}
+
+// NewConfig returns a new Config with defaults.
+func NewConfig() Config {
+	return Config{
+		RateLimit: DefaultRateLimit,
+	}
+}
@@ -0,0 +1,23 @@
+package snapshotter_test
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/influxdata/influxdb/services/snapshotter"
+)
+
+func TestConfig_Parse(t *testing.T) {
+	// Parse configuration.
+	var c snapshotter.Config
+	if _, err := toml.Decode(`
+rate-limit = 1048576
+`, &c); err != nil {
+		t.Fatal(err)
+	}
+
+	// Validate configuration.
+	if c.RateLimit != 1048576 {
+		t.Fatalf("unexpected rate limit: %d", c.RateLimit)
+	}
+}
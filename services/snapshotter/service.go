@@ -2,6 +2,7 @@ package snapshotter // import "github.com/influxdata/influxdb/services/snapshott
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding"
 	"encoding/binary"
 	"encoding/json"
@@ -12,9 +13,12 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/pkg/limiter"
 	"github.com/influxdata/influxdb/services/meta"
 	"github.com/influxdata/influxdb/tsdb"
 )
@@ -24,8 +28,29 @@ const (
 	MuxHeader = 3
 
 	// BackupMagicHeader is the first 8 bytes used to identify and validate
-	// a metastore backup file
+	// a metastore backup file written before the format was versioned.
 	BackupMagicHeader = 0x59590101
+
+	// BackupMagicHeaderVersioned is the first 8 bytes of a metastore backup
+	// file that is followed by the 8 byte version of its format.
+	BackupMagicHeaderVersioned = 0x59590102
+
+	// MetastoreBackupVersion is the version of the metastore backup format
+	// written by this build.
+	MetastoreBackupVersion = 1
+)
+
+// Statistics for the snapshotter service.
+const (
+	statRequests                = "req"                   // Number of requests received
+	statRequestFailures         = "reqFail"               // Number of requests that could not be read or answered
+	statShardBackups            = "shardBackups"          // Number of shard backups sent
+	statShardBackupFailures     = "shardBackupFail"       // Number of shard backups that failed
+	statShardBackupBytes        = "shardBackupBytes"      // Sum of all bytes sent in shard backups
+	statShardBackupDuration     = "shardBackupDurationNs" // Number of (wall-time) nanoseconds spent sending shard backups
+	statShardBackupsActive      = "shardBackupsActive"    // Number of shard backups currently being sent
+	statMetastoreBackups        = "metaBackups"           // Number of metastore backups sent
+	statMetastoreBackupFailures = "metaBackupFail"        // Number of metastore backups that failed
 )
 
 // Service manages the listener for the snapshot endpoint.
@@ -33,6 +58,10 @@ type Service struct {
 	wg  sync.WaitGroup
 	err chan error
 
+	// rate limits the combined rate of all shard backups. Nil if unlimited.
+	rate  *limiter.Rate
+	stats *Statistics
+
 	Node *influxdb.Node
 
 	MetaClient interface {
@@ -40,18 +69,27 @@ type Service struct {
 		Database(name string) *meta.DatabaseInfo
 	}
 
-	TSDBStore *tsdb.Store
+	TSDBStore interface {
+		BackupShard(id uint64, since time.Time, w io.Writer) error
+		Shard(id uint64) *tsdb.Shard
+		ShardRelativePath(id uint64) (string, error)
+	}
 
 	Listener net.Listener
 	Logger   *log.Logger
 }
 
 // NewService returns a new instance of Service.
-func NewService() *Service {
-	return &Service{
+func NewService(c Config) *Service {
+	s := &Service{
 		err:    make(chan error),
+		stats:  &Statistics{},
 		Logger: log.New(os.Stderr, "[snapshot] ", log.LstdFlags),
 	}
+	if c.RateLimit > 0 {
+		s.rate = limiter.NewRate(c.RateLimit)
+	}
+	return s
 }
 
 // Open starts the service.
@@ -81,6 +119,38 @@ func (s *Service) SetLogOutput(w io.Writer) {
 // Err returns a channel for fatal out-of-band errors.
 func (s *Service) Err() <-chan error { return s.err }
 
+// Statistics maintains statistics for the snapshotter service.
+type Statistics struct {
+	Requests                int64
+	RequestFailures         int64
+	ShardBackups            int64
+	ShardBackupFailures     int64
+	ShardBackupBytes        int64
+	ShardBackupDuration     int64
+	ActiveShardBackups      int64
+	MetastoreBackups        int64
+	MetastoreBackupFailures int64
+}
+
+// Statistics returns statistics for periodic monitoring.
+func (s *Service) Statistics(tags map[string]string) []models.Statistic {
+	return []models.Statistic{{
+		Name: "snapshotter",
+		Tags: tags,
+		Values: map[string]interface{}{
+			statRequests:                atomic.LoadInt64(&s.stats.Requests),
+			statRequestFailures:         atomic.LoadInt64(&s.stats.RequestFailures),
+			statShardBackups:            atomic.LoadInt64(&s.stats.ShardBackups),
+			statShardBackupFailures:     atomic.LoadInt64(&s.stats.ShardBackupFailures),
+			statShardBackupBytes:        atomic.LoadInt64(&s.stats.ShardBackupBytes),
+			statShardBackupDuration:     atomic.LoadInt64(&s.stats.ShardBackupDuration),
+			statShardBackupsActive:      atomic.LoadInt64(&s.stats.ActiveShardBackups),
+			statMetastoreBackups:        atomic.LoadInt64(&s.stats.MetastoreBackups),
+			statMetastoreBackupFailures: atomic.LoadInt64(&s.stats.MetastoreBackupFailures),
+		},
+	}}
+}
+
 // serve serves snapshot requests from the listener.
 func (s *Service) serve() {
 	defer s.wg.Done()
@@ -110,31 +180,95 @@ func (s *Service) serve() {
 
 // handleConn processes conn. This is run in a separate goroutine.
 func (s *Service) handleConn(conn net.Conn) error {
+	atomic.AddInt64(&s.stats.Requests, 1)
+
 	r, err := s.readRequest(conn)
 	if err != nil {
+		atomic.AddInt64(&s.stats.RequestFailures, 1)
 		return fmt.Errorf("read request: %s", err)
 	}
 
 	switch r.Type {
 	case RequestShardBackup:
-		if err := s.TSDBStore.BackupShard(r.ShardID, r.Since, conn); err != nil {
+		if err := s.writeShardBackup(conn, r); err != nil {
 			return err
 		}
 	case RequestMetastoreBackup:
+		atomic.AddInt64(&s.stats.MetastoreBackups, 1)
 		if err := s.writeMetaStore(conn); err != nil {
+			atomic.AddInt64(&s.stats.MetastoreBackupFailures, 1)
 			return err
 		}
 	case RequestDatabaseInfo:
-		return s.writeDatabaseInfo(conn, r.Database)
+		if err := s.writeDatabaseInfo(conn, r.Database); err != nil {
+			atomic.AddInt64(&s.stats.RequestFailures, 1)
+			return err
+		}
 	case RequestRetentionPolicyInfo:
-		return s.writeRetentionPolicyInfo(conn, r.Database, r.RetentionPolicy)
+		if err := s.writeRetentionPolicyInfo(conn, r.Database, r.RetentionPolicy); err != nil {
+			atomic.AddInt64(&s.stats.RequestFailures, 1)
+			return err
+		}
 	default:
+		atomic.AddInt64(&s.stats.RequestFailures, 1)
 		return fmt.Errorf("request type unknown: %v", r.Type)
 	}
 
 	return nil
 }
 
+// BackupShard writes a backup of the shard to w. The backup counts toward the
+// statistics and rate limit of the service, so that backups served by other
+// services, like the HTTP backup endpoint, share them.
+func (s *Service) BackupShard(id uint64, since time.Time, w io.Writer) error {
+	return s.backupShard(w, func(w io.Writer) error {
+		return s.TSDBStore.BackupShard(id, since, w)
+	})
+}
+
+// writeShardBackup writes a backup of the requested shard into the connection,
+// compressing it with the requested compression type.
+func (s *Service) writeShardBackup(conn net.Conn, r Request) error {
+	return s.backupShard(conn, func(w io.Writer) error {
+		switch r.Compression {
+		case CompressionNone:
+			return s.TSDBStore.BackupShard(r.ShardID, r.Since, w)
+		case CompressionGzip:
+			w := &gzipWriter{w: w}
+			if err := s.TSDBStore.BackupShard(r.ShardID, r.Since, w); err != nil {
+				return err
+			}
+			return w.Close()
+		default:
+			return fmt.Errorf("compression type unknown: %v", r.Compression)
+		}
+	})
+}
+
+// backupShard calls fn to write a shard backup to w and records the backup in
+// the statistics. The data written by all shard backups together is limited to
+// the configured rate.
+func (s *Service) backupShard(w io.Writer, fn func(w io.Writer) error) error {
+	atomic.AddInt64(&s.stats.ShardBackups, 1)
+	atomic.AddInt64(&s.stats.ActiveShardBackups, 1)
+	start := time.Now()
+	defer func() {
+		atomic.AddInt64(&s.stats.ShardBackupDuration, time.Since(start).Nanoseconds())
+		atomic.AddInt64(&s.stats.ActiveShardBackups, -1)
+	}()
+
+	w = &countingWriter{w: w, n: &s.stats.ShardBackupBytes}
+	if s.rate != nil {
+		w = limiter.NewRateWriter(w, s.rate)
+	}
+
+	if err := fn(w); err != nil {
+		atomic.AddInt64(&s.stats.ShardBackupFailures, 1)
+		return err
+	}
+	return nil
+}
+
 func (s *Service) writeMetaStore(conn net.Conn) error {
 	// Retrieve and serialize the current meta data.
 	metaBlob, err := s.MetaClient.MarshalBinary()
@@ -147,14 +281,15 @@ func (s *Service) writeMetaStore(conn net.Conn) error {
 		return err
 	}
 
-	var numBytes [24]byte
+	var numBytes [32]byte
 
-	binary.BigEndian.PutUint64(numBytes[:8], BackupMagicHeader)
-	binary.BigEndian.PutUint64(numBytes[8:16], uint64(len(metaBlob)))
-	binary.BigEndian.PutUint64(numBytes[16:24], uint64(nodeBytes.Len()))
+	binary.BigEndian.PutUint64(numBytes[:8], BackupMagicHeaderVersioned)
+	binary.BigEndian.PutUint64(numBytes[8:16], MetastoreBackupVersion)
+	binary.BigEndian.PutUint64(numBytes[16:24], uint64(len(metaBlob)))
+	binary.BigEndian.PutUint64(numBytes[24:32], uint64(nodeBytes.Len()))
 
-	// backup header followed by meta blob length
-	if _, err := conn.Write(numBytes[:16]); err != nil {
+	// backup header and version followed by meta blob length
+	if _, err := conn.Write(numBytes[:24]); err != nil {
 		return err
 	}
 
@@ -162,7 +297,7 @@ func (s *Service) writeMetaStore(conn net.Conn) error {
 		return err
 	}
 
-	if _, err := conn.Write(numBytes[16:24]); err != nil {
+	if _, err := conn.Write(numBytes[24:32]); err != nil {
 		return err
 	}
 
@@ -269,14 +404,24 @@ const (
 	RequestRetentionPolicyInfo
 )
 
+// CompressionType is the compression applied to a shard backup stream.
+type CompressionType uint8
+
+const (
+	CompressionNone CompressionType = iota
+	CompressionGzip
+)
+
 // Request represents a request for a specific backup or for information
-// about the shards on this server for a database or retention policy
+// about the shards on this server for a database or retention policy.
+// Servers that predate Compression ignore it and send an uncompressed stream.
 type Request struct {
 	Type            RequestType
 	Database        string
 	RetentionPolicy string
 	ShardID         uint64
 	Since           time.Time
+	Compression     CompressionType
 }
 
 // Response contains the relative paths for all the shards on this server
@@ -284,3 +429,37 @@ type Request struct {
 type Response struct {
 	Paths []string
 }
+
+// countingWriter adds the number of bytes written to w to n.
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	atomic.AddInt64(w.n, int64(n))
+	return n, err
+}
+
+// gzipWriter compresses writes to w. The gzip stream is not started until the
+// first write so that a shard with nothing to backup still sends no data.
+type gzipWriter struct {
+	w  io.Writer
+	gw *gzip.Writer
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if w.gw == nil {
+		w.gw = gzip.NewWriter(w.w)
+	}
+	return w.gw.Write(p)
+}
+
+// Close flushes and terminates the gzip stream, if one was started.
+func (w *gzipWriter) Close() error {
+	if w.gw == nil {
+		return nil
+	}
+	return w.gw.Close()
+}
@@ -1 +1,306 @@
 package snapshotter_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/services/snapshotter"
+	"github.com/influxdata/influxdb/tcp"
+	"github.com/influxdata/influxdb/tsdb"
+)
+
+// Ensure the service reports statistics for the requests it serves.
+func TestService_Statistics(t *testing.T) {
+	s := MustOpenService()
+	defer s.Close()
+
+	s.MustRequest(snapshotter.Request{Type: snapshotter.RequestMetastoreBackup})
+	s.MustRequest(snapshotter.Request{Type: 255})
+	s.Close()
+
+	stats := s.Statistics(nil)
+	if len(stats) != 1 {
+		t.Fatalf("unexpected statistics: %v", stats)
+	}
+	for k, exp := range map[string]int64{
+		"req":            2,
+		"reqFail":        1,
+		"metaBackups":    1,
+		"metaBackupFail": 0,
+	} {
+		if got := stats[0].Values[k]; got != exp {
+			t.Errorf("unexpected %s: exp %d, got %v", k, exp, got)
+		}
+	}
+}
+
+// Ensure the service writes metastore backups with the current format version.
+func TestService_MetastoreBackup(t *testing.T) {
+	s := MustOpenService()
+	defer s.Close()
+
+	b := s.MustRequest(snapshotter.Request{Type: snapshotter.RequestMetastoreBackup})
+	if len(b) < 16 {
+		t.Fatalf("unexpected backup: %q", b)
+	} else if magic := binary.BigEndian.Uint64(b[:8]); magic != snapshotter.BackupMagicHeaderVersioned {
+		t.Fatalf("unexpected magic: %x", magic)
+	} else if version := binary.BigEndian.Uint64(b[8:16]); version != snapshotter.MetastoreBackupVersion {
+		t.Fatalf("unexpected version: %d", version)
+	}
+
+	metaBytes, nodeBytes, err := snapshotter.UnmarshalMetastoreBackup(b)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(metaBytes) != "meta" {
+		t.Fatalf("unexpected meta data: %q", metaBytes)
+	} else if string(nodeBytes) != "null\n" {
+		t.Fatalf("unexpected node data: %q", nodeBytes)
+	}
+}
+
+// Ensure metastore backups written before the format was versioned can be read.
+func TestUnmarshalMetastoreBackup_Unversioned(t *testing.T) {
+	// Replace the versioned magic and the version with the old magic.
+	b := MustMetastoreBackup(snapshotter.MetastoreBackupVersion, "meta", "node")[8:]
+	binary.BigEndian.PutUint64(b[:8], snapshotter.BackupMagicHeader)
+
+	metaBytes, nodeBytes, err := snapshotter.UnmarshalMetastoreBackup(b)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(metaBytes) != "meta" {
+		t.Fatalf("unexpected meta data: %q", metaBytes)
+	} else if string(nodeBytes) != "node" {
+		t.Fatalf("unexpected node data: %q", nodeBytes)
+	}
+}
+
+// Ensure metastore backups with a newer format version are rejected.
+func TestUnmarshalMetastoreBackup_NewerVersion(t *testing.T) {
+	b := MustMetastoreBackup(snapshotter.MetastoreBackupVersion+1, "meta", "node")
+	if _, _, err := snapshotter.UnmarshalMetastoreBackup(b); err == nil || !strings.Contains(err.Error(), "newer than the supported version") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the service compresses shard backups when requested.
+func TestService_ShardBackup_Gzip(t *testing.T) {
+	s := MustOpenService()
+	defer s.Close()
+
+	s.TSDBStore.BackupShardFn = func(id uint64, since time.Time, w io.Writer) error {
+		_, err := w.Write([]byte("shard data"))
+		return err
+	}
+
+	b := s.MustRequest(snapshotter.Request{
+		Type:        snapshotter.RequestShardBackup,
+		ShardID:     1,
+		Compression: snapshotter.CompressionGzip,
+	})
+
+	gr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadAll(gr); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "shard data" {
+		t.Fatalf("unexpected data: %q", data)
+	}
+}
+
+// Ensure the service sends nothing for a compressed backup of a shard with no
+// files to backup, like an uncompressed one.
+func TestService_ShardBackup_Gzip_Empty(t *testing.T) {
+	s := MustOpenService()
+	defer s.Close()
+
+	s.TSDBStore.BackupShardFn = func(id uint64, since time.Time, w io.Writer) error {
+		return nil
+	}
+
+	if b := s.MustRequest(snapshotter.Request{
+		Type:        snapshotter.RequestShardBackup,
+		ShardID:     1,
+		Compression: snapshotter.CompressionGzip,
+	}); len(b) != 0 {
+		t.Fatalf("unexpected data: %q", b)
+	}
+}
+
+// Ensure the rate limit applies to all shard backups together.
+func TestService_ShardBackup_RateLimit(t *testing.T) {
+	c := snapshotter.NewConfig()
+	c.RateLimit = 2000
+	s := MustOpenServiceConfig(c)
+	defer s.Close()
+
+	data := bytes.Repeat([]byte("x"), 2000)
+	s.TSDBStore.BackupShardFn = func(id uint64, since time.Time, w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(id uint64) {
+			defer wg.Done()
+			if b := s.MustRequest(snapshotter.Request{Type: snapshotter.RequestShardBackup, ShardID: id}); len(b) != len(data) {
+				t.Errorf("unexpected backup size: %d", len(b))
+			}
+		}(uint64(i + 1))
+	}
+	wg.Wait()
+
+	// Each backup alone would take one second.
+	if elapsed := time.Since(start); elapsed < 1500*time.Millisecond {
+		t.Fatalf("backups completed too quickly: %s", elapsed)
+	}
+}
+
+// Ensure shard backups for other services are counted in the statistics.
+func TestService_BackupShard(t *testing.T) {
+	s := MustOpenService()
+	defer s.Close()
+
+	s.TSDBStore.BackupShardFn = func(id uint64, since time.Time, w io.Writer) error {
+		_, err := w.Write([]byte("shard data"))
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := s.BackupShard(1, time.Time{}, &buf); err != nil {
+		t.Fatal(err)
+	} else if buf.String() != "shard data" {
+		t.Fatalf("unexpected data: %q", buf.String())
+	}
+
+	stats := s.Statistics(nil)[0].Values
+	for k, exp := range map[string]int64{
+		"req":              0,
+		"shardBackups":     1,
+		"shardBackupFail":  0,
+		"shardBackupBytes": int64(len("shard data")),
+	} {
+		if got := stats[k]; got != exp {
+			t.Errorf("unexpected %s: exp %d, got %v", k, exp, got)
+		}
+	}
+}
+
+// Service is a test wrapper for snapshotter.Service.
+type Service struct {
+	*snapshotter.Service
+	MetaClient MetaClient
+	TSDBStore  TSDBStore
+	ln         net.Listener
+}
+
+// MustMetastoreBackup returns a metastore backup with the format version and
+// the meta and node data.
+func MustMetastoreBackup(version uint64, meta, node string) []byte {
+	var buf bytes.Buffer
+	for _, v := range []uint64{snapshotter.BackupMagicHeaderVersioned, version, uint64(len(meta))} {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	buf.WriteString(meta)
+	binary.Write(&buf, binary.BigEndian, uint64(len(node)))
+	buf.WriteString(node)
+	return buf.Bytes()
+}
+
+// MustOpenService returns a new, open service listening on a random port. Panic on error.
+func MustOpenService() *Service {
+	return MustOpenServiceConfig(snapshotter.NewConfig())
+}
+
+// MustOpenServiceConfig returns an open service like MustOpenService with the
+// configuration c. Panic on error.
+func MustOpenServiceConfig(c snapshotter.Config) *Service {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	mux := tcp.NewMux()
+	go mux.Serve(ln)
+
+	s := &Service{Service: snapshotter.NewService(c), ln: ln}
+	s.Listener = mux.Listen(snapshotter.MuxHeader)
+	s.Service.MetaClient = &s.MetaClient
+	s.Service.TSDBStore = &s.TSDBStore
+	s.SetLogOutput(ioutil.Discard)
+	if err := s.Open(); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Close closes the listener and the service.
+func (s *Service) Close() error {
+	s.ln.Close()
+	return s.Service.Close()
+}
+
+// MustRequest sends r to the service and returns the response. Panic on error.
+func (s *Service) MustRequest(r snapshotter.Request) []byte {
+	conn, err := tcp.Dial("tcp", s.ln.Addr().String(), snapshotter.MuxHeader)
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(r); err != nil {
+		panic(err)
+	}
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// MetaClient is a mockable implementation of the snapshotter meta client.
+type MetaClient struct {
+	DatabaseFn func(name string) *meta.DatabaseInfo
+}
+
+func (c *MetaClient) MarshalBinary() ([]byte, error) { return []byte("meta"), nil }
+
+func (c *MetaClient) Database(name string) *meta.DatabaseInfo {
+	if c.DatabaseFn == nil {
+		return nil
+	}
+	return c.DatabaseFn(name)
+}
+
+// TSDBStore is a mockable implementation of the snapshotter TSDB store.
+type TSDBStore struct {
+	BackupShardFn       func(id uint64, since time.Time, w io.Writer) error
+	ShardFn             func(id uint64) *tsdb.Shard
+	ShardRelativePathFn func(id uint64) (string, error)
+}
+
+func (s *TSDBStore) BackupShard(id uint64, since time.Time, w io.Writer) error {
+	return s.BackupShardFn(id, since, w)
+}
+
+func (s *TSDBStore) Shard(id uint64) *tsdb.Shard {
+	return s.ShardFn(id)
+}
+
+func (s *TSDBStore) ShardRelativePath(id uint64) (string, error) {
+	return s.ShardRelativePathFn(id)
+}
@@ -50,6 +50,11 @@ type Config struct {
 	WALDir            string `toml:"wal-dir"`
 	WALLoggingEnabled bool   `toml:"wal-logging-enabled"`
 
+	// WALFsyncDelay is how long writes wait to be fsynced together with other
+	// writes. WALFsyncMaxBytes fsyncs them early once this many bytes are waiting.
+	WALFsyncDelay    toml.Duration `toml:"wal-fsync-delay"`
+	WALFsyncMaxBytes int           `toml:"wal-fsync-max-bytes"`
+
 	// Query logging
 	QueryLogEnabled bool `toml:"query-log-enabled"`
 
@@ -60,6 +65,11 @@ type Config struct {
 	CompactFullWriteColdDuration   toml.Duration `toml:"compact-full-write-cold-duration"`
 	MaxPointsPerBlock              int           `toml:"max-points-per-block"`
 
+	// QuarantineCorruptFiles moves TSM files whose header or index cannot be
+	// read into a corrupt directory in their shard, so the shard opens without
+	// them. Block checksums are not checked when files are opened.
+	QuarantineCorruptFiles bool `toml:"quarantine-corrupt-files"`
+
 	// Limits
 
 	// MaxSeriesPerDatabase is the maximum number of series a node can hold per database.
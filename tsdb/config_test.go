@@ -13,6 +13,7 @@ func TestConfig_Parse(t *testing.T) {
 	if _, err := toml.Decode(`
 dir = "/var/lib/influxdb/data"
 wal-dir = "/var/lib/influxdb/wal"
+quarantine-corrupt-files = true
 `, &c); err != nil {
 		t.Fatal(err)
 	}
@@ -27,6 +28,9 @@ wal-dir = "/var/lib/influxdb/wal"
 	if got, exp := c.WALDir, "/var/lib/influxdb/wal"; got != exp {
 		t.Errorf("unexpected wal-dir:\n\nexp=%v\n\ngot=%v\n\n", exp, got)
 	}
+	if !c.QuarantineCorruptFiles {
+		t.Errorf("unexpected quarantine-corrupt-files: %v", c.QuarantineCorruptFiles)
+	}
 }
 
 func TestConfig_Validate_Error(t *testing.T) {
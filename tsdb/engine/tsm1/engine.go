@@ -99,7 +99,10 @@ type Engine struct {
 // NewEngine returns a new instance of Engine.
 func NewEngine(path string, walPath string, opt tsdb.EngineOptions) tsdb.Engine {
 	w := NewWAL(walPath)
+	w.SyncDelay = time.Duration(opt.Config.WALFsyncDelay)
+	w.SyncMaxBytes = opt.Config.WALFsyncMaxBytes
 	fs := NewFileStore(path)
+	fs.QuarantineCorruptFiles = opt.Config.QuarantineCorruptFiles
 	cache := NewCache(uint64(opt.Config.CacheMaxMemorySize), path)
 
 	c := &Compactor{
@@ -496,7 +499,12 @@ func (e *Engine) readFileFromBackup(tr *tar.Reader, shardRelativePath string) er
 		return err
 	}
 
+	// Ensure the archive cannot write files outside of the shard directory.
 	destPath := filepath.Join(e.path, path)
+	if rel, err := filepath.Rel(e.path, destPath); err != nil || rel == "." || rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(os.PathSeparator)) || filepath.IsAbs(rel) {
+		return fmt.Errorf("invalid archive path: %s", hdr.Name)
+	}
 	tmp := destPath + ".tmp"
 
 	// Create new file on disk.
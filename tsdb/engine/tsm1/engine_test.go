@@ -213,6 +213,46 @@ func TestEngine_Backup(t *testing.T) {
 	}
 }
 
+// Ensure that the engine will not restore files outside of the shard directory.
+func TestEngine_Restore_InvalidPath(t *testing.T) {
+	for _, tt := range []struct {
+		basePath string
+		name     string
+	}{
+		{basePath: "db/rp/1", name: "db/rp/1/../evil"},
+		{basePath: "db/rp/1", name: "db/rp/1"},
+		{basePath: "", name: "../evil"},
+		{basePath: "", name: "/evil"},
+	} {
+		e := MustOpenEngine()
+
+		data := []byte("evil")
+		b := bytes.NewBuffer(nil)
+		tw := tar.NewWriter(b)
+		if err := tw.WriteHeader(&tar.Header{Name: tt.name, Mode: 0666, Size: int64(len(data))}); err != nil {
+			t.Fatalf("failed to write header: %s", err.Error())
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatalf("failed to write data: %s", err.Error())
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatalf("failed to close tar writer: %s", err.Error())
+		}
+
+		if err := e.Restore(b, tt.basePath); err == nil {
+			t.Errorf("%s: expected error restoring archive", tt.name)
+		}
+
+		for _, fn := range []string{"evil", "evil.tmp", "data.tmp"} {
+			if _, err := os.Stat(filepath.Join(e.root, fn)); !os.IsNotExist(err) {
+				t.Errorf("%s: file written outside of shard directory: %s", tt.name, fn)
+			}
+		}
+
+		e.Close()
+	}
+}
+
 // Ensure engine can create an ascending iterator for cached values.
 func TestEngine_CreateIterator_Cache_Ascending(t *testing.T) {
 	t.Parallel()
@@ -115,6 +115,10 @@ type dereferencer interface {
 	Dereference([]byte)
 }
 
+// CorruptDir is the name of the directory in a shard that TSM files which
+// cannot be opened are moved to.
+const CorruptDir = "corrupt"
+
 // Statistics gathered by the FileStore.
 const (
 	statFileStoreBytes = "diskBytes"
@@ -140,6 +144,10 @@ type FileStore struct {
 
 	currentTempDirID int
 
+	// QuarantineCorruptFiles moves TSM files that cannot be opened to
+	// CorruptDir instead of failing the open.
+	QuarantineCorruptFiles bool
+
 	dereferencer dereferencer
 }
 
@@ -384,8 +392,10 @@ func (f *FileStore) Open() error {
 
 	// struct to hold the result of opening each reader in a goroutine
 	type res struct {
-		r   *TSMReader
-		err error
+		r    *TSMReader
+		path string
+		size int64
+		err  error
 	}
 
 	readerC := make(chan *res)
@@ -406,28 +416,41 @@ func (f *FileStore) Open() error {
 		}
 
 		// Accumulate file store size stat
+		var size int64
 		if fi, err := file.Stat(); err == nil {
-			atomic.AddInt64(&f.stats.DiskBytes, fi.Size())
+			size = fi.Size()
+			atomic.AddInt64(&f.stats.DiskBytes, size)
 		}
 
-		go func(idx int, file *os.File) {
+		go func(idx int, file *os.File, size int64) {
 			start := time.Now()
 			df, err := NewTSMReader(file)
 			f.logger.Printf("%s (#%d) opened in %v", file.Name(), idx, time.Now().Sub(start))
 
 			if err != nil {
-				readerC <- &res{r: df, err: fmt.Errorf("error opening memory map for file %s: %v", file.Name(), err)}
+				file.Close()
+				readerC <- &res{r: df, path: file.Name(), size: size, err: fmt.Errorf("error opening memory map for file %s: %v", file.Name(), err)}
 				return
 			}
 			readerC <- &res{r: df}
-		}(i, file)
+		}(i, file, size)
 	}
 
 	for range files {
 		res := <-readerC
 		if res.err != nil {
+			if !f.QuarantineCorruptFiles {
+				return res.err
+			}
 
-			return res.err
+			// Move the file out of the way so the shard opens with the remaining files.
+			path, err := QuarantineTSMFile(res.path)
+			if err != nil {
+				return fmt.Errorf("error quarantining file %s: %v", res.path, err)
+			}
+			f.logger.Printf("%v: moved to %s", res.err, path)
+			atomic.AddInt64(&f.stats.DiskBytes, -res.size)
+			continue
 		}
 		f.files = append(f.files, res.r)
 	}
@@ -438,6 +461,29 @@ func (f *FileStore) Open() error {
 	return nil
 }
 
+// QuarantineTSMFile moves the TSM file at path, along with its tombstone file if
+// it has one, into the CorruptDir directory next to it. Returns the new path of
+// the TSM file.
+func QuarantineTSMFile(path string) (string, error) {
+	dir := filepath.Join(filepath.Dir(path), CorruptDir)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", err
+	}
+
+	tombstone := (&Tombstoner{Path: path}).tombstonePath()
+	if _, err := os.Stat(tombstone); err == nil {
+		if err := renameFile(tombstone, filepath.Join(dir, filepath.Base(tombstone))); err != nil {
+			return "", err
+		}
+	}
+
+	newPath := filepath.Join(dir, filepath.Base(path))
+	if err := renameFile(path, newPath); err != nil {
+		return "", err
+	}
+	return newPath, nil
+}
+
 func (f *FileStore) Close() error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -1980,6 +1980,58 @@ func TestFileStore_Open(t *testing.T) {
 	}
 }
 
+// Ensure that a corrupt TSM file fails the open unless it is quarantined.
+func TestFileStore_Open_Corrupt(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	// Create 3 TSM files...
+	data := []keyValues{
+		keyValues{"cpu", []tsm1.Value{tsm1.NewValue(0, 1.0)}},
+		keyValues{"cpu", []tsm1.Value{tsm1.NewValue(1, 2.0)}},
+		keyValues{"mem", []tsm1.Value{tsm1.NewValue(0, 1.0)}},
+	}
+
+	files, err := newFileDir(dir, data...)
+	if err != nil {
+		fatal(t, "creating test files", err)
+	}
+
+	// Truncate the second file so that its index can't be read.
+	if err := os.Truncate(files[1], 4); err != nil {
+		fatal(t, "truncating file", err)
+	}
+
+	fs := tsm1.NewFileStore(dir)
+	if err := fs.Open(); err == nil {
+		t.Fatal("expected error opening corrupt file")
+	}
+
+	fs = tsm1.NewFileStore(dir)
+	fs.QuarantineCorruptFiles = true
+	if err := fs.Open(); err != nil {
+		fatal(t, "opening file store", err)
+	}
+	defer fs.Close()
+
+	if got, exp := fs.Count(), 2; got != exp {
+		t.Fatalf("file count mismatch: got %v, exp %v", got, exp)
+	}
+
+	if got, exp := fs.CurrentGeneration(), 4; got != exp {
+		t.Fatalf("current ID mismatch: got %v, exp %v", got, exp)
+	}
+
+	if _, err := os.Stat(files[1]); !os.IsNotExist(err) {
+		t.Fatalf("corrupt file should be moved: %v", err)
+	}
+
+	path := filepath.Join(dir, tsm1.CorruptDir, filepath.Base(files[1]))
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("corrupt file should be quarantined: %v", err)
+	}
+}
+
 func TestFileStore_Remove(t *testing.T) {
 	dir := MustTempDir()
 	defer os.RemoveAll(dir)
@@ -68,6 +68,9 @@ const (
 	statWALCurrentBytes     = "currentSegmentDiskBytes"
 	statWriteOk             = "writeOk"
 	statWriteErr            = "writeErr"
+	statSyncs               = "fsyncs"      // Number of fsyncs of the WAL
+	statSyncedWrites        = "fsyncWrites" // Number of writes made durable by those fsyncs
+	statSyncWaitDuration    = "fsyncWaitNs" // Number of nanoseconds writes spent waiting to be fsynced
 	defaultWaitingWALWrites = 10
 )
 
@@ -93,6 +96,19 @@ type WAL struct {
 	// SegmentSize is the file size at which a segment file will be rotated
 	SegmentSize int
 
+	// SyncDelay is how long a write waits so that it can be fsynced together
+	// with other writes. If zero, every write is fsynced on its own.
+	SyncDelay time.Duration
+
+	// SyncMaxBytes fsyncs the waiting writes without waiting for SyncDelay
+	// once they add up to this many bytes. If zero, there is no limit.
+	SyncMaxBytes int
+
+	// writes waiting for the next fsync
+	syncWaiters   []chan error
+	syncBytes     int
+	syncScheduled bool
+
 	// statistics for the WAL
 	stats   *WALStatistics
 	limiter limiter.Fixed
@@ -142,6 +158,9 @@ type WALStatistics struct {
 	CurrentBytes int64
 	WriteOK      int64
 	WriteErr     int64
+	Syncs        int64
+	SyncedWrites int64
+	SyncWait     int64
 }
 
 // Statistics returns statistics for periodic monitoring.
@@ -150,10 +169,13 @@ func (l *WAL) Statistics(tags map[string]string) []models.Statistic {
 		Name: "tsm1_wal",
 		Tags: tags,
 		Values: map[string]interface{}{
-			statWALOldBytes:     atomic.LoadInt64(&l.stats.OldBytes),
-			statWALCurrentBytes: atomic.LoadInt64(&l.stats.CurrentBytes),
-			statWriteOk:         atomic.LoadInt64(&l.stats.WriteOK),
-			statWriteErr:        atomic.LoadInt64(&l.stats.WriteErr),
+			statWALOldBytes:      atomic.LoadInt64(&l.stats.OldBytes),
+			statWALCurrentBytes:  atomic.LoadInt64(&l.stats.CurrentBytes),
+			statWriteOk:          atomic.LoadInt64(&l.stats.WriteOK),
+			statWriteErr:         atomic.LoadInt64(&l.stats.WriteErr),
+			statSyncs:            atomic.LoadInt64(&l.stats.Syncs),
+			statSyncedWrites:     atomic.LoadInt64(&l.stats.SyncedWrites),
+			statSyncWaitDuration: atomic.LoadInt64(&l.stats.SyncWait),
 		},
 	}}
 }
@@ -307,6 +329,23 @@ func (l *WAL) LastWriteTime() time.Time {
 }
 
 func (l *WAL) writeToLog(entry WALEntry) (int, error) {
+	segID, syncErr, err := l.encodeAndWrite(entry)
+	if err != nil {
+		return -1, err
+	}
+
+	// Wait until the write is durable. The limiter has already been released
+	// so that other writes can join the pending fsync.
+	start := time.Now()
+	err = <-syncErr
+	atomic.AddInt64(&l.stats.SyncWait, time.Since(start).Nanoseconds())
+	return segID, err
+}
+
+// encodeAndWrite encodes and compresses the entry and writes it to the current
+// segment. Returns the ID of the segment and a channel that receives the
+// result of the fsync that makes the write durable.
+func (l *WAL) encodeAndWrite(entry WALEntry) (int, <-chan error, error) {
 	// limit how many concurrent encodings can be in flight.  Since we can only
 	// write one at a time to disk, a slow disk can cause the allocations below
 	// to increase quickly.  If we're backed up, wait until others have completed.
@@ -319,31 +358,38 @@ func (l *WAL) writeToLog(entry WALEntry) (int, error) {
 
 	b, err := entry.Encode(bytes)
 	if err != nil {
-		return -1, err
+		return -1, nil, err
 	}
 
 	encBuf := getBuf(snappy.MaxEncodedLen(len(b)))
 	defer putBuf(encBuf)
 	compressed := snappy.Encode(encBuf, b)
 
+	return l.writeCompressed(entry.Type(), compressed)
+}
+
+// writeCompressed writes the compressed entry to the current segment. Returns
+// the ID of the segment and a channel that receives the result of the fsync
+// that makes the write durable.
+func (l *WAL) writeCompressed(entryType WalEntryType, compressed []byte) (int, <-chan error, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
 	// Make sure the log has not been closed
 	select {
 	case <-l.closing:
-		return -1, ErrWALClosed
+		return -1, nil, ErrWALClosed
 	default:
 	}
 
 	// roll the segment file if needed
 	if err := l.rollSegment(); err != nil {
-		return -1, fmt.Errorf("error rolling WAL segment: %v", err)
+		return -1, nil, fmt.Errorf("error rolling WAL segment: %v", err)
 	}
 
-	// write and sync
-	if err := l.currentSegmentWriter.Write(entry.Type(), compressed); err != nil {
-		return -1, fmt.Errorf("error writing WAL entry: %v", err)
+	// write the entry
+	if err := l.currentSegmentWriter.Write(entryType, compressed); err != nil {
+		return -1, nil, fmt.Errorf("error writing WAL entry: %v", err)
 	}
 
 	// Update stats for current segment size
@@ -351,7 +397,44 @@ func (l *WAL) writeToLog(entry WALEntry) (int, error) {
 
 	l.lastWriteTime = time.Now()
 
-	return l.currentSegmentID, l.currentSegmentWriter.sync()
+	syncErr := make(chan error, 1)
+	l.syncWaiters = append(l.syncWaiters, syncErr)
+	l.syncBytes += len(compressed)
+
+	if l.SyncDelay == 0 || (l.SyncMaxBytes > 0 && l.syncBytes >= l.SyncMaxBytes) {
+		l.sync()
+	} else if !l.syncScheduled {
+		l.syncScheduled = true
+		time.AfterFunc(l.SyncDelay, l.scheduledSync)
+	}
+
+	return l.currentSegmentID, syncErr, nil
+}
+
+// scheduledSync fsyncs the writes that have waited for SyncDelay.
+func (l *WAL) scheduledSync() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.syncScheduled = false
+	l.sync()
+}
+
+// sync fsyncs the current segment and sends the result to the writes waiting
+// for it. The lock must be held.
+func (l *WAL) sync() {
+	if len(l.syncWaiters) == 0 {
+		return
+	}
+
+	err := l.currentSegmentWriter.sync()
+	atomic.AddInt64(&l.stats.Syncs, 1)
+	atomic.AddInt64(&l.stats.SyncedWrites, int64(len(l.syncWaiters)))
+
+	for _, c := range l.syncWaiters {
+		c <- err
+	}
+	l.syncWaiters = nil
+	l.syncBytes = 0
 }
 
 // rollSegment closes the current segment and opens a new one if the current segment is over
@@ -428,6 +511,7 @@ func (l *WAL) Close() error {
 	close(l.closing)
 
 	if l.currentSegmentWriter != nil {
+		l.sync()
 		l.currentSegmentWriter.close()
 		l.currentSegmentWriter = nil
 	}
@@ -449,6 +533,8 @@ func segmentFileNames(dir string) ([]string, error) {
 func (l *WAL) newSegmentFile() error {
 	l.currentSegmentID++
 	if l.currentSegmentWriter != nil {
+		// Writes waiting for an fsync must be durable before the segment is closed.
+		l.sync()
 		if err := l.currentSegmentWriter.close(); err != nil {
 			return err
 		}
@@ -3,7 +3,9 @@ package tsm1_test
 import (
 	"fmt"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
 
@@ -513,6 +515,119 @@ func TestWAL_Delete(t *testing.T) {
 	}
 }
 
+// Ensure that concurrent writes are fsynced together when a sync delay is set.
+func TestWAL_WritePoints_SyncDelay(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	w := tsm1.NewWAL(dir)
+	w.SyncDelay = 100 * time.Millisecond
+	if err := w.Open(); err != nil {
+		t.Fatalf("error opening WAL: %v", err)
+	}
+	defer w.Close()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := w.WritePoints(map[string][]tsm1.Value{
+				"cpu,host=A#!~#value": []tsm1.Value{
+					tsm1.NewValue(int64(i), 1.1),
+				},
+			}); err != nil {
+				t.Errorf("error writing points: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < w.SyncDelay {
+		t.Fatalf("writes returned before the sync delay: %v", elapsed)
+	}
+
+	// A slow writer may miss the first fsync and be synced by another, so
+	// only check that writes were synced together.
+	stats := w.Statistics(nil)[0].Values
+	if got, max := stats["fsyncs"].(int64), int64(5); got >= max {
+		t.Fatalf("writes were not fsynced together: got %v fsyncs for %v writes", got, max)
+	}
+	if got, exp := stats["fsyncWrites"], int64(5); got != exp {
+		t.Fatalf("fsynced write count mismatch: got %v, exp %v", got, exp)
+	}
+}
+
+// Ensure that writes waiting for a delayed fsync do not prevent more writes
+// from joining it.
+func TestWAL_WritePoints_SyncDelay_ManyWriters(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	w := tsm1.NewWAL(dir)
+	w.SyncDelay = 200 * time.Millisecond
+	if err := w.Open(); err != nil {
+		t.Fatalf("error opening WAL: %v", err)
+	}
+	defer w.Close()
+
+	// Use more writers than the number of writes that can be encoded at once.
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := w.WritePoints(map[string][]tsm1.Value{
+				"cpu,host=A#!~#value": []tsm1.Value{
+					tsm1.NewValue(int64(i), 1.1),
+				},
+			}); err != nil {
+				t.Errorf("error writing points: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// A slow writer may miss the first fsync and be synced by another, so
+	// only check that writes were synced together.
+	stats := w.Statistics(nil)[0].Values
+	if got, max := stats["fsyncs"].(int64), int64(n); got >= max {
+		t.Fatalf("writes were not fsynced together: got %v fsyncs for %v writes", got, max)
+	}
+	if got, exp := stats["fsyncWrites"], int64(n); got != exp {
+		t.Fatalf("fsynced write count mismatch: got %v, exp %v", got, exp)
+	}
+}
+
+// Ensure that writes are fsynced without waiting for the sync delay once they
+// exceed the max sync bytes.
+func TestWAL_WritePoints_SyncMaxBytes(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	w := tsm1.NewWAL(dir)
+	w.SyncDelay = time.Hour
+	w.SyncMaxBytes = 1
+	if err := w.Open(); err != nil {
+		t.Fatalf("error opening WAL: %v", err)
+	}
+	defer w.Close()
+
+	if _, err := w.WritePoints(map[string][]tsm1.Value{
+		"cpu,host=A#!~#value": []tsm1.Value{
+			tsm1.NewValue(1, 1.1),
+		},
+	}); err != nil {
+		t.Fatalf("error writing points: %v", err)
+	}
+
+	if got, exp := w.Statistics(nil)[0].Values["fsyncs"], int64(1); got != exp {
+		t.Fatalf("fsync count mismatch: got %v, exp %v", got, exp)
+	}
+}
+
 func TestWALWriter_Corrupt(t *testing.T) {
 	dir := MustTempDir()
 	defer os.RemoveAll(dir)